@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/ari/v5"
+	"github.com/CyCoreSystems/ari/v5/ext/play"
+)
+
+// asteriskDriver drives an instance connected to a real Asterisk. Each call is a
+// Local channel: the ;1 leg is controlled by callsim, the ;2 leg runs the
+// dialplan context and enters the IVR Stasis application.
+type asteriskDriver struct {
+	client  ari.Client // connected with SubscribeAll to see the IVR side events
+	context string     // dialplan context, ex : "ivr-test"
+}
+
+type asteriskCall struct {
+	h       *ari.ChannelHandle
+	journal *journal
+	cancel  context.CancelFunc
+}
+
+func (d *asteriskDriver) Dial(ctx context.Context, sc *Scenario) (call, error) {
+	// Subscribe before originating so no event of the IVR leg is missed
+	sub := d.client.Bus().Subscribe(nil, ari.Events.All)
+
+	h, err := d.client.Channel().Originate(nil, ari.OriginateRequest{
+		Endpoint: fmt.Sprintf("Local/%s@%s/n", sc.Exten, d.context),
+		App:      d.client.ApplicationName(),
+		CallerID: sc.Caller,
+		Timeout:  30,
+	})
+	if err != nil {
+		sub.Cancel()
+		return nil, err
+	}
+	data, err := h.Data()
+	if err != nil {
+		sub.Cancel()
+		h.Hangup()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := &asteriskCall{h: h, journal: newJournal(), cancel: cancel}
+	go c.watch(ctx, sub, strings.TrimSuffix(data.Name, ";1")+";2")
+	return c, nil
+}
+
+// watch turns the events of the IVR leg into journal events
+func (c *asteriskCall) watch(ctx context.Context, sub ari.Subscription, peerName string) {
+	defer sub.Cancel()
+	var peerID string
+	for {
+		var e ari.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok = <-sub.Events():
+			// The subscription was cancelled or the ARI connection closed
+			if !ok {
+				return
+			}
+		}
+
+		switch v := e.(type) {
+		case *ari.StasisStart:
+			if v.Channel.Name == peerName {
+				peerID = v.Channel.ID
+			}
+		case *ari.PlaybackStarted:
+			if peerID != "" && v.Playback.TargetURI == "channel:"+peerID {
+				c.journal.add(event{Kind: eventPrompt, Value: v.Playback.MediaURI})
+			}
+		case *ari.RecordingStarted:
+			if peerID != "" && v.Recording.TargetURI == "channel:"+peerID {
+				c.journal.add(event{Kind: eventRecord, Value: v.Recording.Name})
+			}
+		case *ari.ChannelVarset:
+			if peerID != "" && v.Channel.ID == peerID {
+				c.journal.add(event{Kind: eventVariable, Name: v.Variable, Value: v.Value})
+			}
+		case *ari.ChannelDestroyed:
+			if v.Channel.ID == peerID || v.Channel.ID == c.h.ID() {
+				c.journal.add(event{Kind: eventHangup})
+			}
+		}
+	}
+}
+
+func (c *asteriskCall) Press(digits string) error {
+	return c.h.SendDTMF(digits, nil)
+}
+
+// Say plays the media to the IVR, then presses # to end its recording
+func (c *asteriskCall) Say(ctx context.Context, rec string, audio []byte, mediaURI string) error {
+	if mediaURI == "" {
+		return fmt.Errorf("say cannot inject a local file into Asterisk, use say_uri with a sound available on the server")
+	}
+	// Let the IVR beep before speaking
+	time.Sleep(time.Second)
+	if err := play.Play(ctx, c.h, play.URI(mediaURI)).Err(); err != nil {
+		return err
+	}
+	return c.h.SendDTMF("#", nil)
+}
+
+func (c *asteriskCall) Hangup() error {
+	return c.h.Hangup()
+}
+
+func (c *asteriskCall) Close() {
+	c.h.Hangup()
+	c.cancel()
+}
+
+func (c *asteriskCall) Events() *journal {
+	return c.journal
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ari/internal/fakeari"
+)
+
+// driver places calls into the IVR
+type driver interface {
+	Dial(ctx context.Context, sc *Scenario) (call, error)
+}
+
+// call is the caller side of a call in progress
+type call interface {
+	Press(digits string) error
+	// Say fills the IVR recording named rec, either with WAV audio or a media URI
+	Say(ctx context.Context, rec string, audio []byte, mediaURI string) error
+	Hangup() error
+	Events() *journal
+	// Close hangs up if needed and releases the call
+	Close()
+}
+
+// fakeDriver drives an instance connected to an embedded fake ARI server
+type fakeDriver struct {
+	srv *fakeari.Server
+}
+
+type fakeCall struct {
+	srv     *fakeari.Server
+	id      string
+	journal *journal
+	cancel  context.CancelFunc
+}
+
+func (d *fakeDriver) Dial(ctx context.Context, sc *Scenario) (call, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &fakeCall{
+		srv:     d.srv,
+		id:      fmt.Sprintf("callsim-%d", time.Now().UnixNano()),
+		journal: newJournal(),
+		cancel:  cancel,
+	}
+	go c.watch(ctx, len(d.srv.Commands()))
+
+	err := d.srv.StartCall(fakeari.Call{
+		ID:           c.id,
+		CallerNumber: sc.Caller,
+		Exten:        sc.Exten,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return c, nil
+}
+
+// watch turns the commands the IVR sends for this channel into journal events
+func (c *fakeCall) watch(ctx context.Context, from int) {
+	prefix := "/channels/" + c.id
+	for {
+		cmd, err := c.srv.WaitCommand(ctx, func(cmd fakeari.Command) bool {
+			return cmd.Seq >= from && strings.HasPrefix(cmd.Path, prefix)
+		})
+		if err != nil {
+			return
+		}
+		from = cmd.Seq + 1
+
+		str := func(k string) string {
+			v, _ := cmd.Body[k].(string)
+			return v
+		}
+		action := strings.TrimPrefix(cmd.Path, prefix)
+		switch {
+		case cmd.Method == "POST" && strings.HasPrefix(action, "/play/"):
+			c.journal.add(event{Kind: eventPrompt, Value: str("media")})
+		case cmd.Method == "POST" && action == "/record":
+			c.journal.add(event{Kind: eventRecord, Value: str("name")})
+		case cmd.Method == "POST" && action == "/variable":
+			c.journal.add(event{Kind: eventVariable, Name: str("variable"), Value: str("value")})
+		case cmd.Method == "DELETE" && action == "",
+			cmd.Method == "POST" && action == "/continue":
+			c.journal.add(event{Kind: eventHangup})
+		}
+	}
+}
+
+func (c *fakeCall) Press(digits string) error {
+	return c.srv.SendDTMF(c.id, digits)
+}
+
+func (c *fakeCall) Say(ctx context.Context, rec string, audio []byte, mediaURI string) error {
+	if audio == nil {
+		return fmt.Errorf("say_uri %q cannot be played against the fake ARI, use say with a WAV file", mediaURI)
+	}
+	return c.srv.FinishRecording(rec, audio)
+}
+
+func (c *fakeCall) Hangup() error {
+	return c.srv.EndCall(c.id)
+}
+
+func (c *fakeCall) Close() {
+	c.srv.EndCall(c.id)
+	c.cancel()
+}
+
+func (c *fakeCall) Events() *journal {
+	return c.journal
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Kinds of events observed on the IVR side of a call
+const (
+	eventPrompt   = "prompt"   // Value is the media URI
+	eventRecord   = "record"   // Value is the recording name
+	eventVariable = "variable" // Name and Value of the channel variable
+	eventHangup   = "hangup"
+)
+
+type event struct {
+	Kind  string
+	Name  string
+	Value string
+}
+
+func (e event) String() string {
+	if e.Name != "" {
+		return fmt.Sprintf("%s %s=%q", e.Kind, e.Name, e.Value)
+	}
+	return fmt.Sprintf("%s %s", e.Kind, e.Value)
+}
+
+// journal is the ordered list of events of a call. Expectations consume it from
+// a cursor, so each step only sees what happened after the previous one matched.
+type journal struct {
+	mu     sync.Mutex
+	events []event
+	cursor int
+	taken  map[int]bool // events matched by find, ahead of the cursor
+	notify chan struct{}
+}
+
+func newJournal() *journal {
+	return &journal{taken: map[int]bool{}, notify: make(chan struct{})}
+}
+
+func (j *journal) add(e event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events = append(j.events, e)
+	close(j.notify)
+	j.notify = make(chan struct{})
+}
+
+// next waits for the first event after the cursor matching, and moves the cursor past it
+func (j *journal) next(ctx context.Context, match func(event) bool) (event, error) {
+	for {
+		j.mu.Lock()
+		for j.cursor < len(j.events) {
+			e, taken := j.events[j.cursor], j.taken[j.cursor]
+			j.cursor++
+			if !taken && match(e) {
+				j.mu.Unlock()
+				return e, nil
+			}
+		}
+		notify := j.notify
+		j.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return event{}, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// find waits for the first event after the cursor matching, without moving
+// the cursor: the events before it can still be expected. It suits the events
+// racing with others, like the answer set while its first sentence plays.
+func (j *journal) find(ctx context.Context, match func(event) bool) (event, error) {
+	for i := 0; ; {
+		j.mu.Lock()
+		for i = max(i, j.cursor); i < len(j.events); i++ {
+			if e := j.events[i]; !j.taken[i] && match(e) {
+				j.taken[i] = true
+				j.mu.Unlock()
+				return e, nil
+			}
+		}
+		notify := j.notify
+		j.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return event{}, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// tail describes the last n events, for error messages
+func (j *journal) tail(n int) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	start := max(len(j.events)-n, 0)
+	var parts []string
+	for _, e := range j.events[start:] {
+		parts = append(parts, e.String())
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,108 @@
+// Command callsim plays scripted calls against a running IVR instance, for
+// regression testing of the menus and the conversation flow.
+//
+// Against the fake ARI, callsim serves ARI itself and the instance is started
+// with ARI_URL / ARI_WS_URL pointing to it:
+//
+//	callsim -fake 127.0.0.1:8088 cmd/callsim/scenarios/*.json
+//	ARI_URL=http://127.0.0.1:8088/ari ARI_WS_URL=ws://127.0.0.1:8088/ari/events ./ivr-server
+//
+// Against Asterisk, callsim originates Local channels into a dialplan context
+// sending the call to the IVR Stasis application:
+//
+//	callsim -context ivr-test cmd/callsim/scenarios/*.json
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"ari/internal/fakeari"
+
+	"github.com/CyCoreSystems/ari/v5/client/native"
+	"github.com/charmbracelet/log"
+)
+
+func main() {
+	fakeAddr := flag.String("fake", "", "serve a fake ARI on this address instead of calling Asterisk, ex : 127.0.0.1:8088")
+	app := flag.String("app", os.Getenv("ARI_APPLICATION_NAME"), "Stasis application name of the instance under test (fake ARI)")
+	user := flag.String("user", os.Getenv("ARI_USERNAME"), "ARI username")
+	password := flag.String("password", os.Getenv("ARI_PASSWORD"), "ARI password")
+	ariURL := flag.String("ari-url", os.Getenv("ARI_URL"), "ARI URL (Asterisk)")
+	wsURL := flag.String("ari-ws-url", os.Getenv("ARI_WS_URL"), "ARI websocket URL (Asterisk)")
+	dialplan := flag.String("context", "ivr-test", "dialplan context sending the exten to the IVR (Asterisk)")
+	playback := flag.Duration("playback-duration", 500*time.Millisecond, "length of every prompt (fake ARI)")
+	timeout := flag.Duration("timeout", 30*time.Second, "default timeout of a step")
+	recordingsDir := flag.String("recordings-dir", "", "directory of stored recordings besides the ones of the calls, like the one of Asterisk (fake ARI)")
+	pressDelay := flag.Duration("press-delay", 200*time.Millisecond, "caller reaction time before pressing digits")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("usage: callsim [flags] scenario.json...")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var d driver
+	if *fakeAddr != "" {
+		srv, err := fakeari.New(fakeari.Options{
+			Application:      *app,
+			Username:         *user,
+			Password:         *password,
+			Addr:             *fakeAddr,
+			PlaybackDuration: *playback,
+			RecordingsDir:    *recordingsDir,
+		})
+		if err != nil {
+			log.Fatal("cannot start the fake ARI", "err", err)
+		}
+		defer srv.Close()
+		log.Info("Fake ARI listening, waiting for the instance to connect", "url", srv.URL(), "ws", srv.WebsocketURL())
+		if err := srv.WaitConnected(ctx); err != nil {
+			log.Fatal("instance never connected", "err", err)
+		}
+		// Give the instance time to subscribe to StasisStart after connecting
+		time.Sleep(time.Second)
+		d = &fakeDriver{srv: srv}
+	} else {
+		cl, err := native.Connect(&native.Options{
+			Application:  "callsim",
+			URL:          *ariURL,
+			WebsocketURL: *wsURL,
+			Username:     *user,
+			Password:     *password,
+			SubscribeAll: true,
+		})
+		if err != nil {
+			log.Fatal("connect failed", "err", err)
+		}
+		defer cl.Close()
+		d = &asteriskDriver{client: cl, context: *dialplan}
+	}
+
+	failed := 0
+	for _, path := range flag.Args() {
+		sc, err := loadScenario(path)
+		if err != nil {
+			log.Error("FAIL", "scenario", path, "err", err)
+			failed++
+			continue
+		}
+		if err := runScenario(ctx, d, sc, *timeout, *pressDelay); err != nil {
+			log.Error("FAIL", "scenario", sc.Name, "err", err)
+			failed++
+			continue
+		}
+		log.Info("OK", "scenario", sc.Name)
+	}
+
+	if failed > 0 {
+		log.Fatal("Some scenarios failed", "failed", failed, "total", flag.NArg())
+	}
+	log.Info("All scenarios passed", "total", flag.NArg())
+}
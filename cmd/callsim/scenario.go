@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ari/internal/ivr"
+
+	"github.com/charmbracelet/log"
+)
+
+// Scenario is a scripted call, loaded from a JSON file
+type Scenario struct {
+	Name   string `json:"name"`
+	Caller string `json:"caller"` // caller ID number, ex : "1000"
+	Exten  string `json:"exten"`  // dialed number, ex : "100"
+	Steps  []Step `json:"steps"`
+}
+
+// Step is one action or expectation of a scenario. Exactly one field is expected to be set
+// (besides Timeout).
+type Step struct {
+	Press            string   `json:"press,omitempty"`             // DTMF digits pressed by the caller
+	Say              string   `json:"say,omitempty"`               // WAV file used as the caller's recording, relative to the scenario (fake ARI only)
+	SayURI           string   `json:"say_uri,omitempty"`           // media played by the caller, ex : "sound:hello-world" (Asterisk only)
+	ExpectPrompt     string   `json:"expect_prompt,omitempty"`     // substring of the next prompt media URI, ex : "welcome-ari"
+	ExpectTranscript string   `json:"expect_transcript,omitempty"` // substring of the transcript, case insensitive
+	ExpectAnswer     string   `json:"expect_answer,omitempty"`     // substring of the LLM answer, case insensitive
+	ExpectHangup     bool     `json:"expect_hangup,omitempty"`
+	Hangup           bool     `json:"hangup,omitempty"` // the caller hangs up
+	Wait             Duration `json:"wait,omitempty"`
+	Timeout          Duration `json:"timeout,omitempty"` // overrides -timeout for this step
+}
+
+// Duration is a time.Duration read from a string like "1.5s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (s Step) String() string {
+	switch {
+	case s.Press != "":
+		return fmt.Sprintf("press %q", s.Press)
+	case s.Say != "":
+		return fmt.Sprintf("say %s", s.Say)
+	case s.SayURI != "":
+		return fmt.Sprintf("say %s", s.SayURI)
+	case s.ExpectPrompt != "":
+		return fmt.Sprintf("expect prompt %q", s.ExpectPrompt)
+	case s.ExpectTranscript != "":
+		return fmt.Sprintf("expect transcript %q", s.ExpectTranscript)
+	case s.ExpectAnswer != "":
+		return fmt.Sprintf("expect answer %q", s.ExpectAnswer)
+	case s.ExpectHangup:
+		return "expect hangup"
+	case s.Hangup:
+		return "hang up"
+	case s.Wait > 0:
+		return fmt.Sprintf("wait %s", time.Duration(s.Wait))
+	}
+	return "empty step"
+}
+
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	if sc.Name == "" {
+		sc.Name = path
+	}
+	if len(sc.Steps) == 0 {
+		return nil, fmt.Errorf("scenario %s has no steps", path)
+	}
+	// Audio files are relative to the scenario file
+	for i, step := range sc.Steps {
+		if step.Say != "" && !filepath.IsAbs(step.Say) {
+			sc.Steps[i].Say = filepath.Join(filepath.Dir(path), step.Say)
+		}
+	}
+	return &sc, nil
+}
+
+// runScenario places the call and plays every step in order, stopping at the first failure
+func runScenario(ctx context.Context, d driver, sc *Scenario, stepTimeout time.Duration, pressDelay time.Duration) error {
+	c, err := d.Dial(ctx, sc)
+	if err != nil {
+		return fmt.Errorf("dial failed: %w", err)
+	}
+	defer c.Close()
+
+	for i, step := range sc.Steps {
+		timeout := stepTimeout
+		if step.Timeout > 0 {
+			timeout = time.Duration(step.Timeout)
+		}
+		timeout += time.Duration(step.Wait)
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := runStep(stepCtx, c, step, pressDelay)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, step, err)
+		}
+		log.Info("PASS", "scenario", sc.Name, "step", i+1, "action", step.String())
+	}
+	return nil
+}
+
+func runStep(ctx context.Context, c call, s Step, pressDelay time.Duration) error {
+	switch {
+	case s.Press != "":
+		// Callers never press within a few milliseconds of a prompt starting,
+		// and the IVR only listens for digits once the prompt is playing
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pressDelay):
+		}
+		return c.Press(s.Press)
+
+	case s.Say != "":
+		audio, err := os.ReadFile(s.Say)
+		if err != nil {
+			return err
+		}
+		rec, err := c.Events().next(ctx, kind(eventRecord))
+		if err != nil {
+			return fmt.Errorf("no recording started: %w", err)
+		}
+		return c.Say(ctx, rec.Value, audio, "")
+
+	case s.SayURI != "":
+		rec, err := c.Events().next(ctx, kind(eventRecord))
+		if err != nil {
+			return fmt.Errorf("no recording started: %w", err)
+		}
+		return c.Say(ctx, rec.Value, nil, s.SayURI)
+
+	case s.ExpectPrompt != "":
+		return expect(ctx, c, func(e event) bool {
+			return e.Kind == eventPrompt && strings.Contains(e.Value, s.ExpectPrompt)
+		})
+
+	case s.ExpectTranscript != "":
+		return expectVariable(ctx, c, variableContains(ivr.TranscriptVariable, s.ExpectTranscript))
+
+	case s.ExpectAnswer != "":
+		return expectVariable(ctx, c, variableContains(ivr.AnswerVariable, s.ExpectAnswer))
+
+	case s.ExpectHangup:
+		return expect(ctx, c, kind(eventHangup))
+
+	case s.Hangup:
+		return c.Hangup()
+
+	case s.Wait > 0:
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(s.Wait)):
+		}
+		return nil
+	}
+	return errors.New("empty step")
+}
+
+func expect(ctx context.Context, c call, match func(event) bool) error {
+	_, err := c.Events().next(ctx, match)
+	if err != nil {
+		return fmt.Errorf("not observed: %w (last events: %s)", err, c.Events().tail(5))
+	}
+	return nil
+}
+
+// expectVariable is expect for a channel variable, which may be set after the
+// prompts of the next steps started: the answer is set once the LLM is done,
+// while its first sentences already play
+func expectVariable(ctx context.Context, c call, match func(event) bool) error {
+	_, err := c.Events().find(ctx, match)
+	if err != nil {
+		return fmt.Errorf("not observed: %w (last events: %s)", err, c.Events().tail(5))
+	}
+	return nil
+}
+
+func kind(k string) func(event) bool {
+	return func(e event) bool { return e.Kind == k }
+}
+
+func variableContains(name string, want string) func(event) bool {
+	return func(e event) bool {
+		return e.Kind == eventVariable && e.Name == name &&
+			strings.Contains(strings.ToLower(e.Value), strings.ToLower(want))
+	}
+}
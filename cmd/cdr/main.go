@@ -0,0 +1,75 @@
+// Command cdr prints the call detail records kept by the IVR, to answer
+// questions like "what did the bot tell this customer yesterday?":
+//
+//	cdr -db cdr.db -caller 1000 -since 48h
+//	cdr -db cdr.db -review -since 0
+//	cdr -db cdr.db 2f1c6a0e-7b0e-4c4f-9a57-0d0d1c4f8e21
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"ari/internal/cdr"
+	"ari/internal/envelope"
+
+	"github.com/charmbracelet/log"
+)
+
+func main() {
+	dbPath := flag.String("db", envOr("CDR_PATH", "cdr.db"), "SQLite database of the records")
+	caller := flag.String("caller", "", "only the calls of this caller ID number")
+	since := flag.Duration("since", 24*time.Hour, "only the calls started within this duration (0 for all)")
+	review := flag.Bool("review", false, "only the calls flagged for review")
+	limit := flag.Int("limit", 50, "maximum number of calls printed")
+	flag.Parse()
+
+	store, err := cdr.OpenSQLite(*dbPath)
+	if err != nil {
+		log.Fatal("cannot open the records", "err", err)
+	}
+	defer store.Close()
+	// The transcripts and answers encrypted by the IVR are shown as
+	// "enc:<base64>" without its keys
+	if store.Sealer, err = envelope.FromEnv(); err != nil {
+		log.Fatal("encryption keys", "err", err)
+	}
+
+	ctx := context.Background()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	// Call IDs given as arguments are printed whatever the filters
+	if flag.NArg() > 0 {
+		for _, id := range flag.Args() {
+			r, err := store.Get(ctx, id)
+			if err != nil {
+				log.Fatal("cannot get the record", "call_id", id, "err", err)
+			}
+			enc.Encode(r)
+		}
+		return
+	}
+
+	f := cdr.Filter{Caller: *caller, Review: *review, Limit: *limit}
+	if *since > 0 {
+		f.Since = time.Now().Add(-*since)
+	}
+	records, err := store.List(ctx, f)
+	if err != nil {
+		log.Fatal("cannot list the records", "err", err)
+	}
+	for _, r := range records {
+		enc.Encode(r)
+	}
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
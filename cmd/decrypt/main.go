@@ -0,0 +1,167 @@
+// Command decrypt opens the encrypted audio and records of the archive for an
+// authorized review, with the keys of the IVR (ENCRYPTION with
+// ENCRYPTION_KEY_FILE, or KMS_URL and KMS_TOKEN). Every file opened is logged
+// with the user who did, for the audit trail:
+//
+//	decrypt -out review/ -call 2f1c6a0e-7b0e-4c4f-9a57-0d0d1c4f8e21   # a call of the CDR, from the archive of ARCHIVE
+//	decrypt -out review/ 2026/10/19/2f1c6a0e-7b0e-4c4f-9a57-0d0d1c4f8e21/
+//	decrypt -out review/ -files record.json.enc msg_1234_1700000000.wav.enc
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"ari/internal/archive"
+	"ari/internal/cdr"
+	"ari/internal/envelope"
+
+	"github.com/charmbracelet/log"
+)
+
+func main() {
+	out := flag.String("out", ".", "directory of the decrypted files")
+	files := flag.Bool("files", false, "the arguments are encrypted files instead of archive prefixes")
+	call := flag.String("call", "", "decrypt the archived files of this call ID, found in the CDR")
+	dbPath := flag.String("db", envOr("CDR_PATH", "cdr.db"), "SQLite database of the records, with -call")
+	flag.Parse()
+
+	ctx := context.Background()
+	sealer, err := envelope.FromEnv()
+	if err != nil {
+		log.Fatal("encryption keys", "err", err)
+	}
+	if sealer == nil {
+		log.Fatal("set ENCRYPTION and its keys, as for the IVR")
+	}
+	if err := os.MkdirAll(*out, 0o700); err != nil {
+		log.Fatal("cannot create the output directory", "err", err)
+	}
+	reviewer := "unknown"
+	if u, err := user.Current(); err == nil {
+		reviewer = u.Username
+	}
+	d := &decrypter{sealer: sealer, out: *out, reviewer: reviewer}
+
+	if *files {
+		for _, file := range flag.Args() {
+			if err := d.file(ctx, file); err != nil {
+				log.Fatal("cannot decrypt", "file", file, "err", err)
+			}
+		}
+		return
+	}
+
+	prefixes := flag.Args()
+	if *call != "" {
+		store, err := cdr.OpenSQLite(*dbPath)
+		if err != nil {
+			log.Fatal("cannot open the records", "err", err)
+		}
+		record, err := store.Get(ctx, *call)
+		store.Close()
+		if err != nil {
+			log.Fatal("cannot get the record", "call_id", *call, "err", err)
+		}
+		if record.Archive == "" {
+			log.Fatal("the audio of the call was not archived", "call_id", *call)
+		}
+		prefixes = append(prefixes, record.Archive)
+	}
+	if len(prefixes) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store, err := archive.New()
+	if err != nil {
+		log.Fatal("archive", "err", err)
+	}
+	if store == nil {
+		log.Fatal("set ARCHIVE and its settings, as for the IVR")
+	}
+	store = archive.Encrypt(store, sealer)
+	for _, prefix := range prefixes {
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			log.Fatal("cannot list the archive", "prefix", prefix, "err", err)
+		}
+		if len(objects) == 0 {
+			log.Warn("Nothing archived", "prefix", prefix)
+		}
+		for _, obj := range objects {
+			if err := d.object(ctx, store, obj.Key); err != nil {
+				log.Fatal("cannot decrypt", "key", obj.Key, "err", err)
+			}
+		}
+	}
+}
+
+type decrypter struct {
+	sealer   *envelope.Sealer
+	out      string
+	reviewer string
+}
+
+// object writes the opened archived file key to out/<key>
+func (d *decrypter) object(ctx context.Context, store archive.Store, key string) error {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	rel := filepath.FromSlash(strings.TrimSuffix(key, archive.SealedSuffix))
+	if !filepath.IsLocal(rel) {
+		return fmt.Errorf("invalid archive key %q", key)
+	}
+	dest := filepath.Join(d.out, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		return err
+	}
+	return d.write(dest, r, key)
+}
+
+// file writes the opened local file to out/<name without .enc>
+func (d *decrypter) file(ctx context.Context, file string) error {
+	sealed, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	data, err := d.sealer.Open(ctx, sealed)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(d.out, strings.TrimSuffix(path.Base(filepath.ToSlash(file)), archive.SealedSuffix))
+	return d.write(dest, bytes.NewReader(data), file)
+}
+
+func (d *decrypter) write(dest string, r io.Reader, source string) error {
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("Decrypted for review", "source", source, "file", dest, "reviewer", d.reviewer)
+	return nil
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,203 @@
+// Command kms is a local stand-in for a KMS, for development and for the
+// installations without Vault / OpenBao. It serves the subset of their transit
+// API the IVR uses (ENCRYPTION=kms) to wrap its data keys:
+//
+//	KMS_TOKEN=secret kms -addr :8200 -keys /var/lib/kms
+//
+// Routes, with the header X-Vault-Token: $KMS_TOKEN:
+//
+//	POST /v1/transit/encrypt/<key>  {"plaintext": "<base64>"} -> {"data": {"ciphertext": "vault:v1:..."}}
+//	POST /v1/transit/decrypt/<key>  {"ciphertext": "vault:v1:..."} -> {"data": {"plaintext": "<base64>"}}
+//
+// The keys are <keys>/<key>.key files, created at their first use. Keep the
+// directory out of the backups of the archive: who has both reads the calls.
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"ari/internal/envelope"
+
+	"github.com/charmbracelet/log"
+)
+
+const prefix = "vault:v1:"
+
+var keyName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func main() {
+	addr := flag.String("addr", envOr("KMS_ADDR", ":8200"), "listen address")
+	dir := flag.String("keys", envOr("KMS_KEYS_DIR", "kms-keys"), "directory of the keys")
+	flag.Parse()
+
+	token := os.Getenv("KMS_TOKEN")
+	if token == "" {
+		log.Warn("KMS_TOKEN is not set, anyone reaching the KMS can unwrap the data keys")
+	}
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		log.Fatal("cannot create the keys directory", "dir", *dir, "err", err)
+	}
+
+	k := &kms{dir: *dir, token: token, keys: map[string]cipher.AEAD{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/transit/encrypt/{key}", k.auth(k.encrypt))
+	mux.HandleFunc("POST /v1/transit/decrypt/{key}", k.auth(k.decrypt))
+
+	log.Info("KMS listening", "addr", *addr, "keys", *dir)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		log.Fatal("HTTP server stopped", "err", err)
+	}
+}
+
+type kms struct {
+	dir   string
+	token string
+
+	mu   sync.Mutex
+	keys map[string]cipher.AEAD // by name
+}
+
+func (k *kms) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if k.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Vault-Token")), []byte(k.token)) != 1 {
+			writeError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (k *kms) encrypt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "expected {\"plaintext\": \"<base64>\"}")
+		return
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(req.Plaintext)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "plaintext is not base64")
+		return
+	}
+	gcm, err := k.key(r.PathValue("key"), true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	writeData(w, map[string]string{"ciphertext": prefix + base64.StdEncoding.EncodeToString(sealed)})
+}
+
+func (k *kms) decrypt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "expected {\"ciphertext\": \"vault:v1:...\"}")
+		return
+	}
+	encoded, ok := strings.CutPrefix(req.Ciphertext, prefix)
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if !ok || err != nil {
+		writeError(w, http.StatusBadRequest, "invalid ciphertext")
+		return
+	}
+	gcm, err := k.key(r.PathValue("key"), false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(sealed) < gcm.NonceSize() {
+		writeError(w, http.StatusBadRequest, "invalid ciphertext")
+		return
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "cipher: message authentication failed")
+		return
+	}
+	log.Info("Data key unwrapped", "key", r.PathValue("key"), "remote", r.RemoteAddr)
+	writeData(w, map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)})
+}
+
+// key loads the key name, creating it when create is set
+func (k *kms) key(name string, create bool) (cipher.AEAD, error) {
+	if !keyName.MatchString(name) {
+		return nil, errors.New("invalid key name")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if gcm, ok := k.keys[name]; ok {
+		return gcm, nil
+	}
+
+	path := filepath.Join(k.dir, name+".key")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		data = []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return nil, err
+		}
+		log.Info("Key created", "key", name, "file", path)
+	} else if os.IsNotExist(err) {
+		return nil, errors.New("encryption key not found")
+	} else if err != nil {
+		return nil, err
+	}
+	key, err := envelope.ParseKey(data)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[name] = gcm
+	return gcm, nil
+}
+
+func writeData(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+// writeError answers like Vault, {"errors": ["..."]}
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"errors": []string{msg}})
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
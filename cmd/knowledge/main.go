@@ -0,0 +1,118 @@
+// Command knowledge builds the knowledge base of the IVR (KNOWLEDGE_INDEX)
+// from Markdown, text and PDF documents, with the embedder of
+// KNOWLEDGE_EMBEDDINGS, and searches it:
+//
+//	knowledge -index knowledge.idx docs/ faq.md          # add or update the documents
+//	knowledge -index knowledge.idx -rebuild docs/        # from scratch, ex : after changing the embedder
+//	knowledge -index knowledge.idx -remove docs/old.md
+//	knowledge -index knowledge.idx -search "how much is the premium plan"
+//	knowledge -index knowledge.idx -list
+//
+// Run it again when the documents change: only the changed ones are embedded
+// again. The IVR loads the index at startup.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"ari/internal/knowledge"
+
+	"github.com/charmbracelet/log"
+)
+
+func main() {
+	path := flag.String("index", envOr("KNOWLEDGE_INDEX", "knowledge.idx"), "index file")
+	rebuild := flag.Bool("rebuild", false, "start from an empty index")
+	remove := flag.Bool("remove", false, "remove the documents given as arguments")
+	search := flag.String("search", "", "print the passages found for this question")
+	list := flag.Bool("list", false, "list the indexed documents")
+	topK := flag.Int("k", 3, "passages returned by -search, as KNOWLEDGE_TOP_K")
+	minScore := flag.Float64("min-score", 0.3, "similarity of the passages returned by -search, as KNOWLEDGE_MIN_SCORE")
+	flag.Parse()
+
+	ctx := context.Background()
+	emb, err := knowledge.NewEmbedder()
+	if err != nil {
+		log.Fatal("embedder", "err", err)
+	}
+	idx, err := knowledge.LoadIndex(*path)
+	switch {
+	case *rebuild || errors.Is(err, knowledge.ErrNoIndex):
+		idx = knowledge.NewIndex(emb.Name())
+	case err != nil:
+		log.Fatal("cannot load the index", "err", err)
+	}
+
+	switch {
+	case *search != "":
+		if idx.Embedder != emb.Name() {
+			log.Fatal("the index is built with another embedder", "index", idx.Embedder, "embedder", emb.Name())
+		}
+		base := &knowledge.Base{Index: idx, Embedder: emb, TopK: *topK, MinScore: *minScore, Timeout: time.Minute}
+		passages, err := base.Search(ctx, *search)
+		if err != nil {
+			log.Fatal("search failed", "err", err)
+		}
+		if len(passages) == 0 {
+			log.Warn("No relevant passage", "min_score", base.MinScore)
+		}
+		for _, p := range passages {
+			fmt.Printf("%.3f  %s\n%s\n\n", p.Score, p.Cite(), p.Text)
+		}
+		return
+
+	case *list:
+		sources := make([]string, 0, len(idx.Sources))
+		for s := range idx.Sources {
+			sources = append(sources, s)
+		}
+		sort.Strings(sources)
+		chunks := map[string]int{}
+		for _, c := range idx.Chunks {
+			chunks[c.Source]++
+		}
+		fmt.Printf("embedder %s, %d documents, %d passages\n", idx.Embedder, len(sources), len(idx.Chunks))
+		for _, s := range sources {
+			fmt.Printf("%5d  %s\n", chunks[s], s)
+		}
+		return
+
+	case *remove:
+		for _, source := range flag.Args() {
+			if _, ok := idx.Sources[source]; !ok {
+				log.Warn("Not in the index", "document", source)
+			}
+			idx.Remove(source)
+		}
+
+	default:
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		stats, err := knowledge.Ingest(ctx, idx, emb, flag.Args())
+		if err != nil {
+			log.Fatal("ingestion failed, the index is unchanged", "err", err)
+		}
+		log.Info("Documents ingested", "indexed", stats.Indexed, "unchanged", stats.Unchanged,
+			"removed", stats.Removed, "passages", stats.Chunks)
+	}
+
+	if err := idx.Save(*path); err != nil {
+		log.Fatal("cannot save the index", "err", err)
+	}
+	log.Info("Index saved", "file", *path, "documents", len(idx.Sources), "passages", len(idx.Chunks), "embedder", idx.Embedder)
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,155 @@
+// Command mediahelper runs next to Asterisk and stores the audio uploaded by
+// the IVR (MEDIA_TRANSPORT=ari) in its recordings directory, where it becomes
+// a stored recording of the ARI. Run it as the asterisk user, so the files
+// belong to Asterisk whatever the uid of the IVR:
+//
+//	MEDIA_UPLOAD_TOKEN=secret mediahelper -addr :8089 -dir /var/spool/asterisk/recording
+//
+// Routes, with the bearer token MEDIA_UPLOAD_TOKEN, required unless the helper
+// listens on the loopback only:
+//
+//	PUT /media/<name>.wav     stores the WAV body as <dir>/<name>.wav
+//	DELETE /media/<name>.wav  removes it
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// maxUpload bounds the size of a file, a 10 minutes answer at 8000 Hz is 9.6 MB
+const maxUpload = 64 << 20
+
+func main() {
+	addr := flag.String("addr", envOr("MEDIA_HELPER_ADDR", ":8089"), "listen address")
+	dir := flag.String("dir", envOr("MEDIA_HELPER_DIR", "/var/spool/asterisk/recording"), "recordings directory of Asterisk")
+	flag.Parse()
+
+	token := os.Getenv("MEDIA_UPLOAD_TOKEN")
+	if token == "" {
+		if !loopback(*addr) {
+			log.Fatal("MEDIA_UPLOAD_TOKEN is not set, anyone reaching the helper could write to the recordings directory: set it, or listen on the loopback only", "addr", *addr)
+		}
+		log.Warn("MEDIA_UPLOAD_TOKEN is not set, the uploads are not authenticated", "addr", *addr)
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatal("cannot create the recordings directory", "dir", *dir, "err", err)
+	}
+
+	h := &helper{dir: *dir, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /media/{file}", h.auth(h.put))
+	mux.HandleFunc("DELETE /media/{file}", h.auth(h.delete))
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Info("Media helper listening", "addr", *addr, "dir", *dir)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		log.Fatal("HTTP server stopped", "err", err)
+	}
+}
+
+type helper struct {
+	dir   string
+	token string
+}
+
+func (h *helper) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.token != "" {
+			got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// path returns the file of the request, "" when the name is not a plain WAV name
+func (h *helper) path(r *http.Request) string {
+	file := r.PathValue("file")
+	name, ok := strings.CutSuffix(file, ".wav")
+	if !ok || name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return ""
+	}
+	return filepath.Join(h.dir, file)
+}
+
+func (h *helper) put(w http.ResponseWriter, r *http.Request) {
+	path := h.path(r)
+	if path == "" {
+		http.Error(w, "invalid name, expected <name>.wav", http.StatusBadRequest)
+		return
+	}
+	// Asterisk must never play a partial file
+	tmp, err := os.CreateTemp(h.dir, ".upload-*")
+	if err != nil {
+		log.Error("Cannot create the file", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxUpload))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		log.Error("Cannot store the upload", "file", path, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Info("Stored", "file", path, "bytes", n)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *helper) delete(w http.ResponseWriter, r *http.Request) {
+	path := h.path(r)
+	if path == "" {
+		http.Error(w, "invalid name, expected <name>.wav", http.StatusBadRequest)
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Info("Removed", "file", path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loopback tells whether addr listens on the loopback only, ex : "127.0.0.1:8089"
+// or "localhost:8089", not ":8089"
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
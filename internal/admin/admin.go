@@ -0,0 +1,136 @@
+// Package admin serves the HTTP admin API: the calls in progress and call
+// control (hang up, play a message, transfer, flag for review).
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"ari/internal/ivr"
+
+	"github.com/charmbracelet/log"
+)
+
+// Register adds the admin routes to mux, which require the bearer token
+// ADMIN_TOKEN. Without it the admin API is disabled: the routes show the
+// callers and control the calls, a transfer can dial anywhere.
+func Register(mux *http.ServeMux) {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		log.Warn("ADMIN_TOKEN is not set, the admin API is disabled")
+		return
+	}
+	auth := func(h http.HandlerFunc) http.Handler {
+		return requireToken(token, h)
+	}
+
+	mux.Handle("GET /calls", auth(listCalls))
+	mux.Handle("GET /calls/{id}", auth(getCall))
+	mux.Handle("POST /calls/{id}/hangup", auth(hangupCall))
+	mux.Handle("POST /calls/{id}/say", auth(sayToCall))
+	mux.Handle("POST /calls/{id}/transfer", auth(transferCall))
+	mux.Handle("POST /calls/{id}/review", auth(flagCall))
+}
+
+func listCalls(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ivr.ActiveCalls())
+}
+
+func getCall(w http.ResponseWriter, r *http.Request) {
+	info, err := ivr.GetCall(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func hangupCall(w http.ResponseWriter, r *http.Request) {
+	if err := ivr.HangupCall(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sayToCall(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Text == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `expected {"text": "..."}`})
+		return
+	}
+	if err := ivr.SayToCall(r.Context(), r.PathValue("id"), req.Text); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func transferCall(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Context  string `json:"context"` // the context of the call by default
+		Exten    string `json:"exten"`
+		Priority int    `json:"priority"` // 1 by default
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Exten == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `expected {"exten": "...", "context": "...", "priority": 1}`})
+		return
+	}
+	if err := ivr.TransferCall(r.Context(), r.PathValue("id"), req.Context, req.Exten, req.Priority); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func flagCall(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `expected {"reason": "..."}`})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "admin"
+	}
+	if err := ivr.FlagCall(r.Context(), r.PathValue("id"), req.Reason); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ivr.ErrCallNotFound) {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("Failed to write the admin response", "err", err)
+	}
+}
@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterRequiresToken(t *testing.T) {
+	for _, tc := range []struct {
+		token  string
+		header string
+		want   int
+	}{
+		{token: "", header: "", want: http.StatusNotFound},
+		{token: "", header: "Bearer ", want: http.StatusNotFound},
+		{token: "secret", header: "", want: http.StatusUnauthorized},
+		{token: "secret", header: "Bearer wrong", want: http.StatusUnauthorized},
+		{token: "secret", header: "Bearer secret", want: http.StatusBadRequest}, // authorized, without a body
+	} {
+		t.Setenv("ADMIN_TOKEN", tc.token)
+		mux := http.NewServeMux()
+		Register(mux)
+		req := httptest.NewRequest("POST", "/calls/chan-1/transfer", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("token %q, header %q: got %d, want %d", tc.token, tc.header, w.Code, tc.want)
+		}
+	}
+}
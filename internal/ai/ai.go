@@ -0,0 +1,57 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"ari/internal/metrics"
+	"ari/internal/policy"
+)
+
+// Chat is a conversation with a language model
+type Chat interface {
+	Send(ctx context.Context, message string) (string, error)
+}
+
+// Model opens chats with a language model
+type Model interface {
+	NewChat(ctx context.Context) (Chat, error)
+	Name() string
+}
+
+// New returns the models of LLM_BACKEND, a comma separated list tried in
+// order ("gemini" by default, "openai" or "fake"), each with its own policy
+func New() (*Chain, error) {
+	names := policy.ParseProviders(os.Getenv("LLM_BACKEND"), "gemini")
+	chain, err := policy.ChainFromEnv(metrics.LLM, names)
+	if err != nil {
+		return nil, err
+	}
+	providers := make([]Model, len(names))
+	for i, name := range names {
+		m, err := newProvider(name)
+		if err != nil {
+			return nil, err
+		}
+		p, err := policy.FromEnv(metrics.LLM, name)
+		if err != nil {
+			return nil, err
+		}
+		providers[i] = WithPolicy(m, p)
+	}
+	return &Chain{chain: chain, providers: providers}, nil
+}
+
+func newProvider(name string) (Model, error) {
+	switch name {
+	case "gemini":
+		return Gemini{}, nil
+	case "openai":
+		return NewOpenAI(), nil
+	case "fake":
+		return LoadFake(os.Getenv("FAKE_SCRIPT"))
+	default:
+		return nil, fmt.Errorf("unknown LLM_BACKEND provider %q", name)
+	}
+}
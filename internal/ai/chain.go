@@ -0,0 +1,80 @@
+package ai
+
+import (
+	"context"
+	"strings"
+
+	"ari/internal/policy"
+)
+
+// Chain answers with the first of its models which succeeds
+type Chain struct {
+	chain     *policy.Chain
+	providers []Model
+}
+
+// chainChat opens the chat of a provider the first time it is asked
+type chainChat struct {
+	c     *Chain
+	chats []Chat
+}
+
+// Name lists the models of the chain, ex : gemini-2.5-flash,llama3.2
+func (c *Chain) Name() string {
+	names := make([]string, len(c.providers))
+	for i, m := range c.providers {
+		names[i] = m.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *Chain) NewChat(ctx context.Context) (Chat, error) {
+	return &chainChat{c: c, chats: make([]Chat, len(c.providers))}, nil
+}
+
+// Ping succeeds when one of the models is reachable
+func (c *Chain) Ping(ctx context.Context) error {
+	return c.chain.Ping(ctx, func(ctx context.Context, i int) error {
+		if p, ok := c.providers[i].(interface{ Ping(context.Context) error }); ok {
+			return p.Ping(ctx)
+		}
+		return nil
+	})
+}
+
+func (cc *chainChat) Send(ctx context.Context, message string) (answer string, err error) {
+	err = cc.c.chain.Do(ctx, func(ctx context.Context, i int) error {
+		chat, err := cc.chat(ctx, i)
+		if err != nil {
+			return err
+		}
+		answer, err = chat.Send(ctx, message)
+		return err
+	})
+	return answer, err
+}
+
+// Stream falls through to the next model only while nothing was emitted
+func (cc *chainChat) Stream(ctx context.Context, message string, emit func(text string) error) (answer string, err error) {
+	err = cc.c.chain.Do(ctx, func(ctx context.Context, i int) error {
+		chat, err := cc.chat(ctx, i)
+		if err != nil {
+			return err
+		}
+		answer, err = streamOnce(ctx, chat, message, emit)
+		return err
+	})
+	return answer, err
+}
+
+// chat returns the chat of provider i, opened the first time
+func (cc *chainChat) chat(ctx context.Context, i int) (Chat, error) {
+	if cc.chats[i] == nil {
+		chat, err := cc.c.providers[i].NewChat(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cc.chats[i] = chat
+	}
+	return cc.chats[i], nil
+}
@@ -0,0 +1,143 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fake replays canned answers, for offline and reproducible runs. The answer
+// of the longest key contained in the prompt (case insensitive) is returned,
+// Default otherwise.
+type Fake struct {
+	Answers map[string]string `json:"answers"` // prompt substring -> answer
+	Default string            `json:"default"`
+	// Tools are called before answering when their key is in the prompt, the
+	// answer gets the JSON result of the tool in place of {result}
+	Tools map[string]FakeToolCall `json:"tools,omitempty"`
+	// WordDelay is the time to write each word of a streamed answer, ex : "50ms"
+	WordDelay Duration `json:"word_delay,omitempty"`
+}
+
+// Duration is a time.Duration read from a string like "50ms"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+// FakeToolCall is a tool call of the fake model
+type FakeToolCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args"`
+}
+
+type fakeChat struct {
+	f     *Fake
+	tools *Registry
+}
+
+// LoadFake reads the "llm" section of a fake script file, ex :
+//
+//	{"llm": {"answers": {"opening hours": "We are open from 9 to 5."}, "default": "Sorry?"}}
+//	{"llm": {"answers": {"order": "Your order is {result}."}, "tools": {"order": {"name": "order_status", "args": {"order_id": "1234"}}}}}
+//	{"llm": {"default": "We are open. Come and see us.", "word_delay": "50ms"}}
+func LoadFake(path string) (*Fake, error) {
+	f := &Fake{Default: "This is a test answer."}
+	if path == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script struct {
+		LLM *Fake `json:"llm"`
+	}
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, err
+	}
+	if script.LLM == nil {
+		return f, nil
+	}
+	return script.LLM, nil
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) NewChat(ctx context.Context) (Chat, error) {
+	return fakeChat{f, ToolsFrom(ctx)}, nil
+}
+
+// Answer returns the canned answer for the prompt
+func (f *Fake) Answer(prompt string) string {
+	if k, ok := longestKey(f.Answers, prompt); ok {
+		return f.Answers[k]
+	}
+	return f.Default
+}
+
+// longestKey returns the longest key of m contained in prompt, case insensitive
+func longestKey[V any](m map[string]V, prompt string) (string, bool) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	prompt = strings.ToLower(prompt)
+	for _, k := range keys {
+		if strings.Contains(prompt, strings.ToLower(k)) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func (c fakeChat) Send(ctx context.Context, message string) (string, error) {
+	answer := c.f.Answer(message)
+	if k, ok := longestKey(c.f.Tools, message); ok && c.tools != nil {
+		call := c.f.Tools[k]
+		result, err := json.Marshal(c.tools.Call(ctx, call.Name, call.Args))
+		if err != nil {
+			return "", err
+		}
+		answer = strings.ReplaceAll(answer, "{result}", string(result))
+	}
+	return answer, nil
+}
+
+// Stream emits the answer word by word, WordDelay apart
+func (c fakeChat) Stream(ctx context.Context, message string, emit func(text string) error) (string, error) {
+	answer, err := c.Send(ctx, message)
+	if err != nil {
+		return "", err
+	}
+	for _, word := range strings.SplitAfter(answer, " ") {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(c.f.WordDelay)):
+		}
+		if err := emit(word); err != nil {
+			return "", err
+		}
+	}
+	return answer, nil
+}
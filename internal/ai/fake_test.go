@@ -0,0 +1,119 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeAnswer(t *testing.T) {
+	f := &Fake{
+		Answers: map[string]string{
+			"hours":         "short",
+			"opening hours": "long",
+		},
+		Default: "default",
+	}
+	for prompt, want := range map[string]string{
+		"What are your OPENING HOURS?": "long",
+		"Your hours?":                  "short",
+		"Where are you?":               "default",
+	} {
+		if got := f.Answer(prompt); got != want {
+			t.Errorf("%q: got %q, want %q", prompt, got, want)
+		}
+	}
+}
+
+func TestFakeTools(t *testing.T) {
+	tools := NewRegistry()
+	err := tools.Register(Tool{
+		Name: "order_status",
+		Func: func(ctx context.Context, args json.RawMessage) (any, error) {
+			var a struct {
+				OrderID string `json:"order_id"`
+			}
+			err := json.Unmarshal(args, &a)
+			return "order " + a.OrderID + " shipped", err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fake{
+		Answers: map[string]string{"order": "Status: {result}"},
+		Tools: map[string]FakeToolCall{
+			"order": {Name: "order_status", Args: json.RawMessage(`{"order_id": "1234"}`)},
+		},
+	}
+	ctx := WithTools(context.Background(), tools)
+	chat, err := f.NewChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := chat.Send(ctx, "Where is my order?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `Status: {"output":"order 1234 shipped"}`; answer != want {
+		t.Errorf("got %q, want %q", answer, want)
+	}
+}
+
+func TestFakeStream(t *testing.T) {
+	f := &Fake{Default: "We are open. Come and see us.", WordDelay: Duration(time.Millisecond)}
+	chat, err := f.NewChat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pieces []string
+	answer, err := chat.(Streamer).Stream(context.Background(), "hello", func(text string) error {
+		pieces = append(pieces, text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != f.Default || strings.Join(pieces, "") != f.Default {
+		t.Errorf("got %q from %q", answer, pieces)
+	}
+	if len(pieces) != 7 {
+		t.Errorf("got %d pieces, want one per word", len(pieces))
+	}
+}
+
+func TestLoadFake(t *testing.T) {
+	f, err := LoadFake("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Answer("anything") != "This is a test answer." {
+		t.Errorf("without a script: got %q", f.Answer("anything"))
+	}
+
+	path := filepath.Join(t.TempDir(), "script.json")
+	script := `{"llm": {"answers": {"hours": "9 to 5"}, "default": "Sorry?", "word_delay": "50ms"}}`
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = LoadFake(path); err != nil {
+		t.Fatal(err)
+	}
+	if f.Answer("Opening hours?") != "9 to 5" || f.Answer("?") != "Sorry?" {
+		t.Errorf("with a script: got %+v", f)
+	}
+	if time.Duration(f.WordDelay) != 50*time.Millisecond {
+		t.Errorf("word delay: got %v", time.Duration(f.WordDelay))
+	}
+
+	if err := os.WriteFile(path, []byte(`{"llm": {"word_delay": "soon"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFake(path); err == nil {
+		t.Error("invalid word delay: no error")
+	}
+}
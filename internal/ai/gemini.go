@@ -2,40 +2,173 @@ package ai
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"os"
+	"strings"
+
+	"ari/internal/logging"
+	"ari/internal/policy"
 
-	"github.com/charmbracelet/log"
 	"google.golang.org/genai"
 )
 
-func GeminiClient(ctx context.Context) (*genai.Client, error) {
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  os.Getenv("GEMINI_API_KEY"),
-		Backend: genai.BackendGeminiAPI,
-	})
+// GeminiModel is the Gemini model used for chats
+const GeminiModel = "gemini-2.5-flash"
+
+// Gemini opens chats with Google Gemini, GEMINI_API_KEY is read on each new chat
+type Gemini struct{}
+
+type geminiChat struct {
+	chat  *genai.Chat
+	tools *Registry
+}
+
+func (Gemini) Name() string {
+	return GeminiModel
+}
+
+func (Gemini) NewChat(ctx context.Context) (Chat, error) {
+	client, err := GeminiClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return client, nil
+	tools := ToolsFrom(ctx)
+	chat, err := client.Chats.Create(ctx, GeminiModel, geminiToolsConfig(tools), nil)
+	if err != nil {
+		return nil, err
+	}
+	return geminiChat{chat, tools}, nil
 }
 
-func GeminiChatClient(ctx context.Context, client *genai.Client) (*genai.Chat, error) {
+// Ping checks the model is reachable with GEMINI_API_KEY
+func (Gemini) Ping(ctx context.Context) error {
+	client, err := GeminiClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Models.Get(ctx, GeminiModel, nil)
+	return err
+}
 
-	chat, err := client.Chats.Create(ctx, "gemini-2.5-flash", nil, nil)
+func (c geminiChat) Send(ctx context.Context, message string) (string, error) {
+	answer, err := c.send(ctx, message)
+	return answer, geminiError(err)
+}
 
-	if err != nil {
-		return nil, err
+// Stream emits the text of the answer as the model writes it
+func (c geminiChat) Stream(ctx context.Context, message string, emit func(text string) error) (string, error) {
+	answer, err := c.stream(ctx, message, emit)
+	return answer, geminiError(err)
+}
+
+// geminiError marks the errors about the request itself as permanent
+func geminiError(err error) error {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) && policy.PermanentStatus(apiErr.Code) {
+		return policy.Permanent(err)
 	}
-	return chat, nil
+	return err
 }
 
-func SendGeminiMessage(ctx context.Context, chat *genai.Chat, message string) (string, error) {
+// send sends the message, then the results of the tools the model calls
+// until it answers
+func (c geminiChat) send(ctx context.Context, message string) (_ string, err error) {
+	ran := false
+	defer func() { err = afterTools(ran, err) }()
+	parts := []genai.Part{{Text: message}}
+	for range maxToolRounds {
+		result, err := c.chat.SendMessage(ctx, parts...)
+		if err != nil {
+			return "", err
+		}
+		calls := result.FunctionCalls()
+		if len(calls) == 0 || c.tools == nil {
+			logging.For(ctx, "ai").Debug("Gemini response", "chars", len(result.Text()))
+			return result.Text(), nil
+		}
+		ran = true
+		if parts, err = c.callTools(ctx, calls); err != nil {
+			return "", err
+		}
+	}
+	return "", errToolRounds
+}
 
-	result, err := chat.SendMessage(ctx, genai.Part{Text: message})
-	if err != nil {
-		return "", err
+// stream is send with the answer streamed, the text written before a tool
+// call being emitted too
+func (c geminiChat) stream(ctx context.Context, message string, emit func(text string) error) (_ string, err error) {
+	ran := false
+	defer func() { err = afterTools(ran, err) }()
+	parts := []genai.Part{{Text: message}}
+	var answer strings.Builder
+	for range maxToolRounds {
+		var calls []*genai.FunctionCall
+		for chunk, err := range c.chat.SendMessageStream(ctx, parts...) {
+			if err != nil {
+				return "", err
+			}
+			calls = append(calls, chunk.FunctionCalls()...)
+			if text := chunk.Text(); text != "" {
+				answer.WriteString(text)
+				if err := emit(text); err != nil {
+					return "", err
+				}
+			}
+		}
+		if len(calls) == 0 || c.tools == nil {
+			logging.For(ctx, "ai").Debug("Gemini response streamed", "chars", answer.Len())
+			return answer.String(), nil
+		}
+		ran = true
+		if parts, err = c.callTools(ctx, calls); err != nil {
+			return "", err
+		}
+	}
+	return "", errToolRounds
+}
+
+// callTools runs the tools called by the model, their results are the next
+// message
+func (c geminiChat) callTools(ctx context.Context, calls []*genai.FunctionCall) ([]genai.Part, error) {
+	var parts []genai.Part
+	for _, call := range calls {
+		args, err := json.Marshal(call.Args)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, genai.Part{FunctionResponse: &genai.FunctionResponse{
+			ID:       call.ID,
+			Name:     call.Name,
+			Response: c.tools.Call(ctx, call.Name, args),
+		}})
+	}
+	return parts, nil
+}
+
+// geminiToolsConfig declares the tools to the model, nil without tools
+func geminiToolsConfig(tools *Registry) *genai.GenerateContentConfig {
+	if tools == nil {
+		return nil
 	}
-	log.Info("Gemini response:", "response", result.Text())
-	return result.Text(), nil
+	var declarations []*genai.FunctionDeclaration
+	for _, t := range tools.Tools() {
+		declarations = append(declarations, &genai.FunctionDeclaration{
+			Name:                 t.Name,
+			Description:          t.Description,
+			ParametersJsonSchema: t.Parameters,
+		})
+	}
+	return &genai.GenerateContentConfig{Tools: []*genai.Tool{{FunctionDeclarations: declarations}}}
+}
 
+func GeminiClient(ctx context.Context) (*genai.Client, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  os.Getenv("GEMINI_API_KEY"),
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
 }
@@ -0,0 +1,284 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"ari/internal/logging"
+	"ari/internal/policy"
+)
+
+// OpenAI opens chats with a model served by an OpenAI-compatible chat
+// completions API, like a local Ollama, vLLM or llama.cpp server
+type OpenAI struct {
+	BaseURL string // ex : http://localhost:11434/v1
+	APIKey  string // optional for local servers
+	Model   string
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`   // tools called by the assistant
+	ToolCallID string           `json:"tool_call_id,omitempty"` // call answered by a tool message
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON object
+	} `json:"function"`
+}
+
+type openAIChat struct {
+	o        OpenAI
+	tools    *Registry
+	messages []openAIMessage // history, sent with each request
+}
+
+// NewOpenAI returns the model of OPENAI_MODEL (llama3.2 by default) served at
+// OPENAI_BASE_URL (a local Ollama by default), with the optional OPENAI_API_KEY
+func NewOpenAI() OpenAI {
+	o := OpenAI{
+		BaseURL: os.Getenv("OPENAI_BASE_URL"),
+		APIKey:  os.Getenv("OPENAI_API_KEY"),
+		Model:   os.Getenv("OPENAI_MODEL"),
+	}
+	if o.BaseURL == "" {
+		o.BaseURL = "http://localhost:11434/v1"
+	}
+	o.BaseURL = strings.TrimSuffix(o.BaseURL, "/")
+	if o.Model == "" {
+		o.Model = "llama3.2"
+	}
+	return o
+}
+
+func (o OpenAI) Name() string {
+	return o.Model
+}
+
+func (o OpenAI) NewChat(ctx context.Context) (Chat, error) {
+	return &openAIChat{o: o, tools: ToolsFrom(ctx)}, nil
+}
+
+// Ping checks the server answers and accepts the key
+func (o OpenAI) Ping(ctx context.Context) error {
+	resp, err := o.do(ctx, "GET", "/models", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Send sends the message, then the results of the tools the model calls
+// until it answers. The history keeps the whole exchange once it succeeded.
+func (c *openAIChat) Send(ctx context.Context, message string) (string, error) {
+	return c.send(ctx, message, nil)
+}
+
+// Stream emits the content of the answer as the model writes it
+func (c *openAIChat) Stream(ctx context.Context, message string, emit func(text string) error) (string, error) {
+	return c.send(ctx, message, emit)
+}
+
+// send is Send, streaming the answers to emit when it is not nil
+func (c *openAIChat) send(ctx context.Context, message string, emit func(text string) error) (_ string, err error) {
+	ran := false
+	defer func() { err = afterTools(ran, err) }()
+	complete := c.complete
+	if emit != nil {
+		complete = func(ctx context.Context, messages []openAIMessage) (openAIMessage, error) {
+			return c.completeStream(ctx, messages, emit)
+		}
+	}
+	messages := append(slices.Clip(c.messages), openAIMessage{Role: "user", Content: message})
+	for range maxToolRounds {
+		answer, err := complete(ctx, messages)
+		if err != nil {
+			return "", err
+		}
+		messages = append(messages, answer)
+		if len(answer.ToolCalls) == 0 || c.tools == nil {
+			c.messages = messages
+			logging.For(ctx, "ai").Debug("OpenAI response", "model", c.o.Model, "chars", len(answer.Content))
+			return answer.Content, nil
+		}
+		ran = true
+		for _, call := range answer.ToolCalls {
+			result, err := json.Marshal(c.tools.Call(ctx, call.Function.Name, json.RawMessage(call.Function.Arguments)))
+			if err != nil {
+				return "", err
+			}
+			messages = append(messages, openAIMessage{Role: "tool", Content: string(result), ToolCallID: call.ID})
+		}
+	}
+	return "", errToolRounds
+}
+
+// complete returns the next message of the assistant
+func (c *openAIChat) complete(ctx context.Context, messages []openAIMessage) (openAIMessage, error) {
+	resp, err := c.o.do(ctx, "POST", "/chat/completions", c.request(messages))
+	if err != nil {
+		return openAIMessage{}, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Choices []struct {
+			Message openAIMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return openAIMessage{}, fmt.Errorf("openai: invalid response: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return openAIMessage{}, errors.New("openai: response without choices")
+	}
+	return result.Choices[0].Message, nil
+}
+
+// completeStream returns the next message of the assistant, read from the
+// server-sent events of a streamed completion, its content emitted as it
+// arrives
+func (c *openAIChat) completeStream(ctx context.Context, messages []openAIMessage, emit func(text string) error) (openAIMessage, error) {
+	request := c.request(messages)
+	request["stream"] = true
+	resp, err := c.o.do(ctx, "POST", "/chat/completions", request)
+	if err != nil {
+		return openAIMessage{}, err
+	}
+	defer resp.Body.Close()
+
+	answer := openAIMessage{Role: "assistant"}
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int    `json:"index"`
+						ID       string `json:"id"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return openAIMessage{}, fmt.Errorf("openai: invalid stream event: %w", err)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		// The calls come in pieces, the arguments a few characters at a time
+		for _, piece := range delta.ToolCalls {
+			for len(answer.ToolCalls) <= piece.Index {
+				answer.ToolCalls = append(answer.ToolCalls, openAIToolCall{Type: "function"})
+			}
+			call := &answer.ToolCalls[piece.Index]
+			if piece.ID != "" {
+				call.ID = piece.ID
+			}
+			call.Function.Name += piece.Function.Name
+			call.Function.Arguments += piece.Function.Arguments
+		}
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			if err := emit(delta.Content); err != nil {
+				return openAIMessage{}, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return openAIMessage{}, fmt.Errorf("openai: reading the stream: %w", err)
+	}
+	for i := range answer.ToolCalls {
+		if answer.ToolCalls[i].Function.Arguments == "" {
+			answer.ToolCalls[i].Function.Arguments = "{}"
+		}
+	}
+	answer.Content = content.String()
+	return answer, nil
+}
+
+// request is the body of a completion of messages
+func (c *openAIChat) request(messages []openAIMessage) map[string]any {
+	request := map[string]any{
+		"model":    c.o.Model,
+		"messages": messages,
+	}
+	if c.tools != nil {
+		var tools []map[string]any
+		for _, t := range c.tools.Tools() {
+			tools = append(tools, map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name":        t.Name,
+					"description": t.Description,
+					"parameters":  t.Parameters,
+				},
+			})
+		}
+		request["tools"] = tools
+	}
+	return request
+}
+
+// do sends a request to the API, the response has a 2xx status
+func (o OpenAI) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, o.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		err := fmt.Errorf("openai returned %s", resp.Status)
+		if policy.PermanentStatus(resp.StatusCode) {
+			return nil, policy.Permanent(err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,54 @@
+package ai
+
+import (
+	"context"
+
+	"ari/internal/policy"
+)
+
+// WithPolicy applies p to every message sent to the chats of m
+func WithPolicy(m Model, p *policy.Policy) Model {
+	return guarded{m, p}
+}
+
+type guarded struct {
+	Model
+	policy *policy.Policy
+}
+
+type guardedChat struct {
+	Chat
+	policy *policy.Policy
+}
+
+func (g guarded) NewChat(ctx context.Context) (Chat, error) {
+	chat, err := g.Model.NewChat(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return guardedChat{chat, g.policy}, nil
+}
+
+func (g guarded) Ping(ctx context.Context) error {
+	if p, ok := g.Model.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c guardedChat) Send(ctx context.Context, message string) (answer string, err error) {
+	err = c.policy.Do(ctx, func(ctx context.Context) error {
+		answer, err = c.Chat.Send(ctx, message)
+		return err
+	})
+	return answer, err
+}
+
+// Stream is not retried once part of the answer was emitted
+func (c guardedChat) Stream(ctx context.Context, message string, emit func(text string) error) (answer string, err error) {
+	err = c.policy.Do(ctx, func(ctx context.Context) error {
+		answer, err = streamOnce(ctx, c.Chat, message, emit)
+		return err
+	})
+	return answer, err
+}
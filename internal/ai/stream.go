@@ -0,0 +1,130 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ari/internal/policy"
+)
+
+// Streamer is a chat streaming its answers as the model writes them
+type Streamer interface {
+	// Stream sends the message like Send, calling emit with each piece of the
+	// answer as it arrives. It returns the whole answer.
+	Stream(ctx context.Context, message string, emit func(text string) error) (string, error)
+}
+
+// Stream sends the message to the chat, emitting the answer as the model
+// writes it when the chat streams, at once when it does not
+func Stream(ctx context.Context, chat Chat, message string, emit func(text string) error) (string, error) {
+	if s, ok := chat.(Streamer); ok {
+		return s.Stream(ctx, message, emit)
+	}
+	answer, err := chat.Send(ctx, message)
+	if err != nil {
+		return "", err
+	}
+	if answer != "" {
+		if err := emit(answer); err != nil {
+			return "", err
+		}
+	}
+	return answer, nil
+}
+
+// streamOnce streams the message, marking the error with policy.Partial once
+// part of the answer was emitted, so it is not retried or sent to another
+// provider
+func streamOnce(ctx context.Context, chat Chat, message string, emit func(text string) error) (string, error) {
+	emitted := false
+	answer, err := Stream(ctx, chat, message, func(text string) error {
+		emitted = true
+		return emit(text)
+	})
+	if err != nil && emitted {
+		return "", policy.Partial(err)
+	}
+	return answer, err
+}
+
+// maxSentence is the length over which a sentence is cut at a comma, so a
+// long one is not waited for
+const maxSentence = 200
+
+// abbreviations do not end a sentence with their dot
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "st": true, "vs": true,
+	"etc": true, "e.g": true, "i.e": true, "approx": true,
+}
+
+// Sentences cuts a streamed answer in sentences, each spoken as soon as it is
+// complete
+type Sentences struct {
+	buf strings.Builder
+}
+
+// Add appends a piece of the answer and returns the sentences it completed
+func (s *Sentences) Add(text string) []string {
+	s.buf.WriteString(text)
+	var sentences []string
+	for {
+		rest := s.buf.String()
+		end := sentenceEnd(rest)
+		if end < 0 {
+			return sentences
+		}
+		if sentence := strings.TrimSpace(rest[:end]); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		s.buf.Reset()
+		s.buf.WriteString(rest[end:])
+	}
+}
+
+// Flush returns the end of the answer, "" when it ended with a sentence
+func (s *Sentences) Flush() string {
+	rest := strings.TrimSpace(s.buf.String())
+	s.buf.Reset()
+	return rest
+}
+
+// sentenceEnd returns the end of the first sentence of text, -1 while it is
+// not complete. A sentence ends with . ! ? or a line break followed by a
+// space: the next piece tells whether "3." is "3.5".
+func sentenceEnd(text string) int {
+	lastComma := -1
+	for i, r := range text {
+		next, size := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+		switch {
+		case r == '\n':
+			if strings.TrimSpace(text[:i]) != "" {
+				return i + 1
+			}
+		case r == '.' || r == '!' || r == '?' || r == '…':
+			if size == 0 || !unicode.IsSpace(next) {
+				continue
+			}
+			if r == '.' && abbreviation(text[:i]) {
+				continue
+			}
+			return i + utf8.RuneLen(r)
+		case r == ',' || r == ';':
+			if size > 0 && unicode.IsSpace(next) {
+				lastComma = i + 1
+			}
+		}
+	}
+	if len(text) > maxSentence && lastComma > 0 {
+		return lastComma
+	}
+	return -1
+}
+
+// abbreviation reports whether the text ends with an abbreviation, its dot
+// not ending the sentence
+func abbreviation(text string) bool {
+	word := text[strings.LastIndexFunc(text, unicode.IsSpace)+1:]
+	return abbreviations[strings.ToLower(strings.TrimLeft(word, "(\"'"))]
+}
@@ -0,0 +1,178 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"ari/internal/logging"
+	"ari/internal/metrics"
+	"ari/internal/policy"
+	"ari/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxToolRounds bounds the tool calls of one message, a model calling tools
+// in a loop gets an error
+const maxToolRounds = 5
+
+// ToolFunc runs a tool with the arguments chosen by the model, a JSON object
+// matching the schema of the tool. The result is sent back to the model as
+// JSON; ctx is the one of the message, carrying the call.
+type ToolFunc func(ctx context.Context, args json.RawMessage) (any, error)
+
+// Tool is a Go function the model can call while answering
+type Tool struct {
+	Name        string // ex : "order_status", letters, digits and underscores
+	Description string // tells the model when to call it
+	// Parameters is the JSON schema of the arguments, an object, ex :
+	//	{"type": "object", "properties": {"order_id": {"type": "string"}}, "required": ["order_id"]}
+	Parameters map[string]any
+	Func       ToolFunc
+}
+
+var toolName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Registry holds the tools offered to the models. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	tools []Tool
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a tool, replacing the tool of the same name
+func (r *Registry) Register(t Tool) error {
+	if !toolName.MatchString(t.Name) {
+		return fmt.Errorf("invalid tool name %q", t.Name)
+	}
+	if t.Func == nil {
+		return fmt.Errorf("tool %s has no function", t.Name)
+	}
+	if t.Parameters == nil {
+		t.Parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.tools {
+		if r.tools[i].Name == t.Name {
+			r.tools[i] = t
+			return nil
+		}
+	}
+	r.tools = append(r.tools, t)
+	return nil
+}
+
+// Tools returns the registered tools, in the order of registration
+func (r *Registry) Tools() []Tool {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Tool(nil), r.tools...)
+}
+
+// Call runs the tool name. The errors are for the model, which can tell the
+// caller or try again: the result is {"error": "..."} then.
+func (r *Registry) Call(ctx context.Context, name string, args json.RawMessage) map[string]any {
+	start := time.Now()
+	ctx, span := tracing.Tracer.Start(ctx, "tool", trace.WithAttributes(attribute.String("tool.name", name)))
+	result, err := r.call(ctx, name, args)
+	tracing.End(span, err)
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	metrics.ToolCalls.WithLabelValues(name, status).Inc()
+	log := logging.For(ctx, "ai")
+	if err != nil {
+		log.Warn("Tool failed", "tool", name, "err", err, "duration", time.Since(start))
+		return map[string]any{"error": err.Error()}
+	}
+	log.Info("Tool called", "tool", name, "duration", time.Since(start))
+	return map[string]any{"output": result}
+}
+
+func (r *Registry) call(ctx context.Context, name string, args json.RawMessage) (result any, err error) {
+	var tool *Tool
+	for _, t := range r.Tools() {
+		if t.Name == name {
+			tool = &t
+			break
+		}
+	}
+	if tool == nil {
+		return nil, fmt.Errorf("unknown tool %q", name)
+	}
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("tool %s panicked: %v", name, p)
+		}
+	}()
+	return tool.Func(ctx, args)
+}
+
+// errToolRounds is returned when a message needs more than maxToolRounds
+// rounds of tool calls
+var errToolRounds = errors.New("too many rounds of tool calls")
+
+// afterTools marks the error of a message with policy.Partial once tools ran
+// for it: retried, or sent to another model, the message would run them again
+// and book the appointment twice
+func afterTools(ran bool, err error) error {
+	if ran && err != nil {
+		return policy.Partial(err)
+	}
+	return err
+}
+
+type toolsKey struct{}
+
+// WithTools returns ctx offering the tools of r to the chats opened with it
+func WithTools(ctx context.Context, r *Registry) context.Context {
+	return context.WithValue(ctx, toolsKey{}, r)
+}
+
+// ToolsFrom returns the tools offered in ctx, nil when there are none
+func ToolsFrom(ctx context.Context) *Registry {
+	r, _ := ctx.Value(toolsKey{}).(*Registry)
+	if len(r.Tools()) == 0 {
+		return nil
+	}
+	return r
+}
+
+// Call is the phone call the messages are sent in, for the tools
+type Call struct {
+	ID     string `json:"id"`
+	Caller string `json:"caller"`
+	Exten  string `json:"exten"` // dialed number
+}
+
+type callKey struct{}
+
+// WithCall returns ctx carrying the call
+func WithCall(ctx context.Context, c Call) context.Context {
+	return context.WithValue(ctx, callKey{}, c)
+}
+
+// CallFrom returns the call of ctx, the zero Call outside of a call
+func CallFrom(ctx context.Context) Call {
+	c, _ := ctx.Value(callKey{}).(Call)
+	return c
+}
@@ -0,0 +1,54 @@
+// Package archive keeps the audio and the records of the past calls once they
+// ended, in a local directory or an S3-compatible bucket (AWS S3, MinIO...).
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Get for an unknown key
+var ErrNotFound = errors.New("archived object not found")
+
+// Object is a file of the archive
+type Object struct {
+	Key      string // slash separated, ex : 2026/10/19/<call id>/record.json
+	Size     int64
+	Modified time.Time
+}
+
+// Store keeps files under slash separated keys
+type Store interface {
+	// Put stores size bytes of r under key, replacing a previous version
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key, an unknown key is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// New returns the store selected by ARCHIVE: none (the default, nil), local
+// or s3
+func New() (Store, error) {
+	switch backend := os.Getenv("ARCHIVE"); backend {
+	case "", "none":
+		return nil, nil
+	case "local":
+		return NewLocal()
+	case "s3":
+		return NewS3()
+	default:
+		return nil, fmt.Errorf("unknown ARCHIVE %q", backend)
+	}
+}
+
+// CallPrefix returns the prefix of the files of a call, by day of its start
+// so the oldest calls are listed first
+func CallPrefix(start time.Time, callID string) string {
+	return start.UTC().Format("2006/01/02") + "/" + callID + "/"
+}
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"ari/internal/envelope"
+)
+
+// SealedSuffix ends the keys of the encrypted files
+const SealedSuffix = ".enc"
+
+// Encrypted seals the files put in Store, stored under their key with
+// SealedSuffix, and opens them on Get. The files archived before the
+// encryption was enabled are still read as they are.
+type Encrypted struct {
+	Store  Store
+	Sealer *envelope.Sealer
+}
+
+// Encrypt returns store sealing its files with sealer, store itself when
+// either is nil
+func Encrypt(store Store, sealer *envelope.Sealer) Store {
+	if store == nil || sealer == nil {
+		return store
+	}
+	return &Encrypted{Store: store, Sealer: sealer}
+}
+
+func (e *Encrypted) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sealed, err := e.Sealer.Seal(ctx, data)
+	if err != nil {
+		return err
+	}
+	return e.Store.Put(ctx, strings.TrimSuffix(key, SealedSuffix)+SealedSuffix, bytes.NewReader(sealed), int64(len(sealed)))
+}
+
+// Get returns the opened file of key, with or without SealedSuffix
+func (e *Encrypted) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := e.Store.Get(ctx, strings.TrimSuffix(key, SealedSuffix)+SealedSuffix)
+	if errors.Is(err, ErrNotFound) && !strings.HasSuffix(key, SealedSuffix) {
+		return e.Store.Get(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.Sealer.Open(ctx, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key string) error {
+	if strings.HasSuffix(key, SealedSuffix) {
+		return e.Store.Delete(ctx, key)
+	}
+	return errors.Join(e.Store.Delete(ctx, key+SealedSuffix), e.Store.Delete(ctx, key))
+}
+
+// List returns the keys as stored, with SealedSuffix for the encrypted files
+func (e *Encrypted) List(ctx context.Context, prefix string) ([]Object, error) {
+	return e.Store.List(ctx, prefix)
+}
+
+// Ping checks the store can be reached
+func (e *Encrypted) Ping(ctx context.Context) error {
+	if p, ok := e.Store.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+var _ Store = (*Encrypted)(nil)
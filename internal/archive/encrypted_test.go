@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ari/internal/envelope"
+)
+
+// encrypted returns a local archive in a temporary directory, encrypted
+// with a new key file
+func encrypted(t *testing.T) (*Encrypted, *Local) {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ivr.key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENCRYPTION", "file")
+	t.Setenv("ENCRYPTION_KEY_FILE", keyFile)
+	sealer, err := envelope.FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &Local{Dir: t.TempDir()}
+	return Encrypt(local, sealer).(*Encrypted), local
+}
+
+func get(t *testing.T, s Store, key string) ([]byte, error) {
+	t.Helper()
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	e, local := encrypted(t)
+	ctx := context.Background()
+	audio := []byte("RIFF audio of the question")
+	const key = "2026/10/19/chan-1/msg_chan-1.wav"
+	if err := e.Put(ctx, key, bytes.NewReader(audio), int64(len(audio))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored sealed under the key with SealedSuffix
+	sealed, err := os.ReadFile(filepath.Join(local.Dir, filepath.FromSlash(key+SealedSuffix)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envelope.IsSealed(sealed) || bytes.Contains(sealed, audio) {
+		t.Error("the archived file is not sealed")
+	}
+	if _, err := os.Stat(filepath.Join(local.Dir, filepath.FromSlash(key))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a plain file was archived: %v", err)
+	}
+
+	for _, k := range []string{key, key + SealedSuffix} {
+		got, err := get(t, e, k)
+		if err != nil || !bytes.Equal(got, audio) {
+			t.Errorf("%s: got %q, %v", k, got, err)
+		}
+	}
+	objects, err := e.List(ctx, "2026/")
+	if err != nil || len(objects) != 1 || objects[0].Key != key+SealedSuffix {
+		t.Errorf("list: got %+v, %v", objects, err)
+	}
+}
+
+func TestEncryptedPlainFiles(t *testing.T) {
+	e, local := encrypted(t)
+	ctx := context.Background()
+	// Archived before the encryption was enabled
+	if err := local.Put(ctx, "old/record.json", strings.NewReader("{}"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := get(t, e, "old/record.json"); err != nil || string(got) != "{}" {
+		t.Errorf("plain file: got %q, %v", got, err)
+	}
+	if _, err := get(t, e, "old/missing.wav"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+func TestEncryptedTampered(t *testing.T) {
+	e, local := encrypted(t)
+	ctx := context.Background()
+	if err := e.Put(ctx, "a.wav", strings.NewReader("audio"), 5); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(local.Dir, "a.wav"+SealedSuffix)
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if err := os.WriteFile(path, sealed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(t, e, "a.wav"); err == nil {
+		t.Error("tampered file: opened")
+	}
+}
+
+func TestEncryptedDelete(t *testing.T) {
+	e, local := encrypted(t)
+	ctx := context.Background()
+	if err := e.Put(ctx, "a.wav", strings.NewReader("new"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Put(ctx, "a.wav", strings.NewReader("old"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Delete(ctx, "a.wav"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := local.List(ctx, "")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("after the delete: got %+v, %v", objects, err)
+	}
+}
+
+func TestEncryptNil(t *testing.T) {
+	local := &Local{Dir: t.TempDir()}
+	if Encrypt(local, nil) != Store(local) {
+		t.Error("without a sealer: the store is wrapped")
+	}
+	if Encrypt(nil, &envelope.Sealer{}) != nil {
+		t.Error("without a store: not nil")
+	}
+}
@@ -0,0 +1,118 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local keeps the files in a directory, the keys being their relative paths
+type Local struct {
+	Dir string
+}
+
+// NewLocal archives to ARCHIVE_DIR (/var/lib/ivr/archive by default)
+func NewLocal() (*Local, error) {
+	dir := os.Getenv("ARCHIVE_DIR")
+	if dir == "" {
+		dir = "/var/lib/ivr/archive"
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("cannot create the archive directory: %w", err)
+	}
+	return &Local{Dir: dir}, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	// A file of the archive is whole or missing
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return file, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Drop the directories left empty, up to Dir
+	for dir := filepath.Dir(path); dir != filepath.Clean(l.Dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(l.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// path returns the file of key, rejecting the keys reaching outside of Dir
+func (l *Local) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid archive key %q", key)
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+var _ Store = (*Local)(nil)
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 keeps the files in a bucket of an S3-compatible storage, under Prefix
+type S3 struct {
+	Bucket string
+	Prefix string
+
+	client *minio.Client
+}
+
+// NewS3 archives to S3_BUCKET at S3_ENDPOINT (https://s3.amazonaws.com by
+// default, ex : http://minio:9000), under S3_PREFIX. The keys are
+// S3_ACCESS_KEY and S3_SECRET_KEY, or else the AWS environment variables and
+// the IAM role of the host.
+func NewS3() (*S3, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET is required with ARCHIVE=s3")
+	}
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q, expected http(s)://host[:port]", endpoint)
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.IAM{},
+	})
+	if key := os.Getenv("S3_ACCESS_KEY"); key != "" {
+		creds = credentials.NewStaticV4(key, os.Getenv("S3_SECRET_KEY"), "")
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:  creds,
+		Secure: u.Scheme == "https",
+		Region: os.Getenv("S3_REGION"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(os.Getenv("S3_PREFIX"), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{Bucket: bucket, Prefix: prefix, client: client}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.Bucket, s.Prefix+key, r, size, minio.PutObjectOptions{
+		ContentType: contentType(key),
+	})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.Bucket, s.Prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, Stat does the request
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.Bucket, s.Prefix+key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for info := range s.client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: s.Prefix + prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, Object{
+			Key:      strings.TrimPrefix(info.Key, s.Prefix),
+			Size:     info.Size,
+			Modified: info.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// Ping checks the bucket exists and the keys can reach it
+func (s *S3) Ping(ctx context.Context) error {
+	ok, err := s.client.BucketExists(ctx, s.Bucket)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("bucket %s does not exist", s.Bucket)
+	}
+	return nil
+}
+
+func contentType(key string) string {
+	switch {
+	case strings.HasSuffix(key, ".wav"):
+		return "audio/wav"
+	case strings.HasSuffix(key, ".json"):
+		return "application/json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+var _ Store = (*S3)(nil)
@@ -0,0 +1,29 @@
+package ariutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// DownloadRecording returns the audio of a stored recording of the ARI
+func DownloadRecording(ctx context.Context, name string) ([]byte, error) {
+	u := fmt.Sprintf("%s/recordings/stored/%s/file", os.Getenv("ARI_URL"), url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(os.Getenv("ARI_USERNAME"), os.Getenv("ARI_PASSWORD"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("recording %s: ARI returned %s", name, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
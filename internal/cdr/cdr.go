@@ -0,0 +1,148 @@
+// Package cdr keeps a call detail record per call: who called, the menus they
+// went through, what the bot heard and answered at each turn, and how the call
+// ended.
+package cdr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"ari/internal/envelope"
+)
+
+// ErrNotFound is returned by Store.Get for an unknown call
+var ErrNotFound = errors.New("call record not found")
+
+// Record is the detail record of one call
+type Record struct {
+	CallID      string    `json:"call_id"`
+	Channel     string    `json:"channel"`
+	Caller      string    `json:"caller"`
+	Exten       string    `json:"exten"` // dialed number
+	Start       time.Time `json:"start"` // entered the Stasis app
+	Answer      time.Time `json:"answer,omitzero"`
+	End         time.Time `json:"end,omitzero"`
+	Menus       []Menu    `json:"menus,omitempty"` // menu path, in order
+	Turns       []Turn    `json:"turns,omitempty"`
+	Errors      []string  `json:"errors,omitempty"` // errors outside of the turns
+	HangupCause string    `json:"hangup_cause,omitempty"`
+	Review      string    `json:"review,omitempty"`  // why the call is flagged for review, "" when it is not
+	Archive     string    `json:"archive,omitempty"` // prefix of its audio in the archive
+	Consent     *Consent  `json:"consent,omitempty"` // nil when no consent was asked
+}
+
+// Consent is the answer of the caller to the recording notice
+type Consent struct {
+	Decision string    `json:"decision"` // accepted, refused, notified or no answer
+	Method   string    `json:"method"`   // notice, dtmf or speech
+	Time     time.Time `json:"time"`
+	Input    string    `json:"input,omitempty"` // digit pressed or masked transcript heard
+}
+
+// Menu is a digit pressed in a menu
+type Menu struct {
+	Menu  string    `json:"menu"` // ex : "welcome-ari"
+	Digit string    `json:"digit"`
+	Time  time.Time `json:"time"`
+}
+
+// Turn is a question of the caller and the answer of the bot
+type Turn struct {
+	Number        int       `json:"number"`
+	Start         time.Time `json:"start"`
+	Recording     string    `json:"recording"`
+	Transcript    string    `json:"transcript,omitempty"`
+	Answer        string    `json:"answer,omitempty"`
+	TTSFile       string    `json:"tts_file,omitempty"`
+	STTLatency    Duration  `json:"stt_latency,omitzero"`
+	LLMLatency    Duration  `json:"llm_latency,omitzero"`
+	TTSLatency    Duration  `json:"tts_latency,omitzero"`
+	AnswerLatency Duration  `json:"answer_latency,omitzero"` // validation to playback
+	STTProvider   string    `json:"stt_provider,omitempty"`  // provider which served the turn
+	LLMProvider   string    `json:"llm_provider,omitempty"`
+	TTSProvider   string    `json:"tts_provider,omitempty"`
+	Sources       []Source  `json:"sources,omitempty"` // passages of the knowledge base given to the LLM
+	Error         string    `json:"error,omitempty"`
+}
+
+// Source is a passage of the knowledge base cited in a turn
+type Source struct {
+	Ref   string  `json:"ref"`   // ex : "docs/plans.md#Pricing", "docs/guide.pdf#page=4"
+	Score float64 `json:"score"` // similarity to the question
+}
+
+// Duration is a time.Duration written as a string like "1.5s" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Filter selects records in Store.List, zero fields match everything
+type Filter struct {
+	Caller string
+	Since  time.Time
+	Until  time.Time
+	Review bool // only the calls flagged for review
+	Limit  int
+}
+
+// Store persists call records
+type Store interface {
+	Save(ctx context.Context, r *Record) error
+	Get(ctx context.Context, callID string) (*Record, error)
+	// List returns the matching records, most recent first
+	List(ctx context.Context, f Filter) ([]*Record, error)
+	Close() error
+}
+
+// New returns the store selected by CDR_STORE (sqlite by default, or none),
+// encrypting the transcripts and answers with sealer, nil keeps them in clear
+func New(sealer *envelope.Sealer) (Store, error) {
+	switch store := os.Getenv("CDR_STORE"); store {
+	case "", "sqlite":
+		path := os.Getenv("CDR_PATH")
+		if path == "" {
+			path = "cdr.db"
+		}
+		store, err := OpenSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		// The transcripts and answers are encrypted as the archive is
+		store.Sealer = sealer
+		return store, nil
+	case "none":
+		return Discard{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CDR_STORE %q", store)
+	}
+}
+
+// Discard is a store dropping every record
+type Discard struct{}
+
+func (Discard) Save(context.Context, *Record) error { return nil }
+
+func (Discard) Get(context.Context, string) (*Record, error) { return nil, ErrNotFound }
+
+func (Discard) List(context.Context, Filter) ([]*Record, error) { return nil, nil }
+
+func (Discard) Close() error { return nil }
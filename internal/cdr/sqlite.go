@@ -0,0 +1,391 @@
+package cdr
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"ari/internal/envelope"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS calls (
+	call_id      TEXT PRIMARY KEY,
+	channel      TEXT NOT NULL,
+	caller       TEXT NOT NULL,
+	exten        TEXT NOT NULL,
+	start        TEXT NOT NULL,
+	answer       TEXT NOT NULL,
+	end          TEXT NOT NULL,
+	menus        TEXT NOT NULL,
+	errors       TEXT NOT NULL,
+	hangup_cause TEXT NOT NULL,
+	review       TEXT NOT NULL DEFAULT '',
+	archive      TEXT NOT NULL DEFAULT '',
+	consent      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS calls_caller_start ON calls (caller, start);
+CREATE INDEX IF NOT EXISTS calls_start ON calls (start);
+
+CREATE TABLE IF NOT EXISTS turns (
+	call_id        TEXT NOT NULL REFERENCES calls (call_id) ON DELETE CASCADE,
+	number         INTEGER NOT NULL,
+	start          TEXT NOT NULL,
+	recording      TEXT NOT NULL,
+	transcript     TEXT NOT NULL,
+	answer         TEXT NOT NULL,
+	tts_file       TEXT NOT NULL,
+	stt_us         INTEGER NOT NULL,
+	llm_us         INTEGER NOT NULL,
+	tts_us         INTEGER NOT NULL,
+	answer_us      INTEGER NOT NULL,
+	error          TEXT NOT NULL,
+	stt_provider   TEXT NOT NULL DEFAULT '',
+	llm_provider   TEXT NOT NULL DEFAULT '',
+	tts_provider   TEXT NOT NULL DEFAULT '',
+	sources        TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (call_id, number)
+);
+`
+
+// migrations bring the databases created by older versions to the schema: the
+// columns added since, created when the table lacks them
+var migrations = []struct{ table, column, definition string }{
+	{"turns", "stt_provider", "TEXT NOT NULL DEFAULT ''"},
+	{"turns", "llm_provider", "TEXT NOT NULL DEFAULT ''"},
+	{"turns", "tts_provider", "TEXT NOT NULL DEFAULT ''"},
+	{"calls", "review", "TEXT NOT NULL DEFAULT ''"},
+	{"calls", "archive", "TEXT NOT NULL DEFAULT ''"},
+	{"calls", "consent", "TEXT NOT NULL DEFAULT ''"},
+	{"turns", "sources", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrate adds the columns of migrations missing from db
+func migrate(db *sql.DB) error {
+	for _, m := range migrations {
+		var exists bool
+		err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, m.table, m.column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLite stores the records in a SQLite database file
+type SQLite struct {
+	// Sealer encrypts the transcripts and answers, nil stores them in clear.
+	// Without it the encrypted ones are read as "enc:<base64>".
+	Sealer *envelope.Sealer
+
+	db *sql.DB
+}
+
+// sealedPrefix starts the encrypted texts
+const sealedPrefix = "enc:"
+
+// OpenSQLite opens (or creates) the database at path
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot create the CDR schema in %s: %w", path, err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot migrate the CDR schema in %s: %w", path, err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Save inserts the record, replacing a previous version of the same call
+func (s *SQLite) Save(ctx context.Context, r *Record) error {
+	menus, err := json.Marshal(r.Menus)
+	if err != nil {
+		return err
+	}
+	errs, err := json.Marshal(r.Errors)
+	if err != nil {
+		return err
+	}
+	consent, err := s.marshalConsent(ctx, r.Consent)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM turns WHERE call_id = ?`, r.CallID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT OR REPLACE INTO calls (call_id, channel, caller, exten, start, answer, end, menus, errors, hangup_cause, review, archive, consent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.CallID, r.Channel, r.Caller, r.Exten,
+		formatTime(r.Start), formatTime(r.Answer), formatTime(r.End),
+		string(menus), string(errs), r.HangupCause, r.Review, r.Archive, consent,
+	)
+	if err != nil {
+		return err
+	}
+	for _, t := range r.Turns {
+		transcript, err := s.seal(ctx, t.Transcript)
+		if err != nil {
+			return err
+		}
+		answer, err := s.seal(ctx, t.Answer)
+		if err != nil {
+			return err
+		}
+		sources := ""
+		if len(t.Sources) > 0 {
+			data, err := json.Marshal(t.Sources)
+			if err != nil {
+				return err
+			}
+			sources = string(data)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO turns (call_id, number, start, recording, transcript, answer, tts_file, stt_us, llm_us, tts_us, answer_us, error,
+				stt_provider, llm_provider, tts_provider, sources)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.CallID, t.Number, formatTime(t.Start), t.Recording, transcript, answer, t.TTSFile,
+			micros(t.STTLatency), micros(t.LLMLatency), micros(t.TTSLatency), micros(t.AnswerLatency),
+			t.Error, t.STTProvider, t.LLMProvider, t.TTSProvider, sources,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) Get(ctx context.Context, callID string) (*Record, error) {
+	records, err := s.query(ctx, `WHERE call_id = ?`, callID)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrNotFound
+	}
+	return records[0], nil
+}
+
+func (s *SQLite) List(ctx context.Context, f Filter) ([]*Record, error) {
+	var where []string
+	var args []any
+	if f.Caller != "" {
+		where = append(where, "caller = ?")
+		args = append(args, f.Caller)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "start >= ?")
+		args = append(args, formatTime(f.Since))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "start < ?")
+		args = append(args, formatTime(f.Until))
+	}
+	if f.Review {
+		where = append(where, "review != ''")
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = "WHERE " + strings.Join(where, " AND ")
+	}
+	clause += " ORDER BY start DESC"
+	if f.Limit > 0 {
+		clause += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	return s.query(ctx, clause, args...)
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// query loads the calls selected by clause with their turns
+func (s *SQLite) query(ctx context.Context, clause string, args ...any) ([]*Record, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT call_id, channel, caller, exten, start, answer, end, menus, errors, hangup_cause, review, archive, consent FROM calls `+clause,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*Record
+	for rows.Next() {
+		var r Record
+		var start, answer, end, menus, errs, consent string
+		err := rows.Scan(&r.CallID, &r.Channel, &r.Caller, &r.Exten, &start, &answer, &end, &menus, &errs, &r.HangupCause, &r.Review, &r.Archive, &consent)
+		if err != nil {
+			return nil, err
+		}
+		r.Start, r.Answer, r.End = parseTime(start), parseTime(answer), parseTime(end)
+		if err := json.Unmarshal([]byte(menus), &r.Menus); err != nil {
+			return nil, fmt.Errorf("call %s: invalid menus: %w", r.CallID, err)
+		}
+		if err := json.Unmarshal([]byte(errs), &r.Errors); err != nil {
+			return nil, fmt.Errorf("call %s: invalid errors: %w", r.CallID, err)
+		}
+		if r.Consent, err = s.unmarshalConsent(ctx, consent); err != nil {
+			return nil, fmt.Errorf("call %s: invalid consent: %w", r.CallID, err)
+		}
+		records = append(records, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		if r.Turns, err = s.turns(ctx, r.CallID); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (s *SQLite) turns(ctx context.Context, callID string) ([]Turn, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT number, start, recording, transcript, answer, tts_file, stt_us, llm_us, tts_us, answer_us, error,
+			stt_provider, llm_provider, tts_provider, sources
+		FROM turns WHERE call_id = ? ORDER BY number`, callID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var turns []Turn
+	for rows.Next() {
+		var t Turn
+		var start, sources string
+		var sttUs, llmUs, ttsUs, answerUs int64
+		err := rows.Scan(&t.Number, &start, &t.Recording, &t.Transcript, &t.Answer, &t.TTSFile,
+			&sttUs, &llmUs, &ttsUs, &answerUs, &t.Error, &t.STTProvider, &t.LLMProvider, &t.TTSProvider, &sources)
+		if err != nil {
+			return nil, err
+		}
+		t.Start = parseTime(start)
+		if sources != "" {
+			if err := json.Unmarshal([]byte(sources), &t.Sources); err != nil {
+				return nil, fmt.Errorf("call %s turn %d: invalid sources: %w", callID, t.Number, err)
+			}
+		}
+		if t.Transcript, err = s.open(ctx, t.Transcript); err != nil {
+			return nil, fmt.Errorf("call %s turn %d: %w", callID, t.Number, err)
+		}
+		if t.Answer, err = s.open(ctx, t.Answer); err != nil {
+			return nil, fmt.Errorf("call %s turn %d: %w", callID, t.Number, err)
+		}
+		t.STTLatency = Duration(sttUs * int64(time.Microsecond))
+		t.LLMLatency = Duration(llmUs * int64(time.Microsecond))
+		t.TTSLatency = Duration(ttsUs * int64(time.Microsecond))
+		t.AnswerLatency = Duration(answerUs * int64(time.Microsecond))
+		turns = append(turns, t)
+	}
+	return turns, rows.Err()
+}
+
+// marshalConsent returns the consent as stored, its input encrypted like the
+// transcripts, "" when there is none
+func (s *SQLite) marshalConsent(ctx context.Context, c *Consent) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	stored := *c
+	var err error
+	if stored.Input, err = s.seal(ctx, c.Input); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
+
+func (s *SQLite) unmarshalConsent(ctx context.Context, data string) (*Consent, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var c Consent
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, err
+	}
+	var err error
+	if c.Input, err = s.open(ctx, c.Input); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// seal encrypts text when a Sealer is set
+func (s *SQLite) seal(ctx context.Context, text string) (string, error) {
+	if s.Sealer == nil || text == "" {
+		return text, nil
+	}
+	sealed, err := s.Sealer.Seal(ctx, []byte(text))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a text written by seal, when a Sealer is set
+func (s *SQLite) open(ctx context.Context, text string) (string, error) {
+	encoded, ok := strings.CutPrefix(text, sealedPrefix)
+	if !ok || s.Sealer == nil {
+		return text, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	data, err := s.Sealer.Open(ctx, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Latencies are stored in microseconds
+func micros(d Duration) int64 {
+	return time.Duration(d).Microseconds()
+}
+
+// Times are stored as UTC RFC 3339 text so they sort lexically, the zero time as ""
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timeLayout)
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.Parse(timeLayout, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+var _ Store = (*SQLite)(nil)
@@ -0,0 +1,55 @@
+package cdr
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// oldSchema is the schema of the first version of the store
+const oldSchema = `
+CREATE TABLE calls (
+	call_id TEXT PRIMARY KEY, channel TEXT NOT NULL, caller TEXT NOT NULL, exten TEXT NOT NULL,
+	start TEXT NOT NULL, answer TEXT NOT NULL, end TEXT NOT NULL, menus TEXT NOT NULL,
+	errors TEXT NOT NULL, hangup_cause TEXT NOT NULL
+);
+CREATE TABLE turns (
+	call_id TEXT NOT NULL REFERENCES calls (call_id) ON DELETE CASCADE, number INTEGER NOT NULL,
+	start TEXT NOT NULL, recording TEXT NOT NULL, transcript TEXT NOT NULL, answer TEXT NOT NULL,
+	tts_file TEXT NOT NULL, stt_us INTEGER NOT NULL, llm_us INTEGER NOT NULL, tts_us INTEGER NOT NULL,
+	answer_us INTEGER NOT NULL, error TEXT NOT NULL,
+	PRIMARY KEY (call_id, number)
+);
+INSERT INTO calls VALUES ('call-1', 'chan-1', '1000', '100', '', '', '', '[]', '[]', '');
+`
+
+func TestOpenSQLiteMigrates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cdr.db")
+	old, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(oldSchema); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	// Migrated once, then opened as it is
+	for range 2 {
+		s, err := OpenSQLite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range migrations {
+			var n int
+			if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, m.table, m.column).Scan(&n); err != nil || n != 1 {
+				t.Errorf("%s.%s: %d columns, %v", m.table, m.column, n, err)
+			}
+		}
+		var consent string
+		if err := s.db.QueryRow(`SELECT consent FROM calls WHERE call_id = 'call-1'`).Scan(&consent); err != nil || consent != "" {
+			t.Errorf("old record: got %q, %v", consent, err)
+		}
+		s.Close()
+	}
+}
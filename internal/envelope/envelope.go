@@ -0,0 +1,158 @@
+// Package envelope encrypts the personal data kept at rest, the archived audio
+// and the call records, with envelope encryption: every object is sealed with
+// AES-256-GCM under its own random data key, and the data key is stored next
+// to it wrapped by a key encryption key, from a key file or a KMS.
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// magic starts every sealed object
+var magic = []byte("IVRENC1\n")
+
+// KeyWrapper protects the data keys with a key encryption key
+type KeyWrapper interface {
+	// Wrap encrypts a data key and returns the ID of the key which did
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts a data key wrapped by the key keyID
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// header describes how an object is sealed, it is authenticated with it
+type header struct {
+	Alg     string `json:"alg"`
+	KeyID   string `json:"kid"`
+	DataKey []byte `json:"dek"` // wrapped
+}
+
+const alg = "AES-256-GCM"
+
+// Sealer seals and opens objects with data keys wrapped by Keys
+type Sealer struct {
+	Keys KeyWrapper
+}
+
+// FromEnv returns the sealer of ENCRYPTION: none (the default, nil), file
+// (ENCRYPTION_KEY_FILE) or kms (KMS_URL)
+func FromEnv() (*Sealer, error) {
+	var keys KeyWrapper
+	var err error
+	switch mode := os.Getenv("ENCRYPTION"); mode {
+	case "", "none":
+		return nil, nil
+	case "file":
+		keys, err = NewFileKeys()
+	case "kms":
+		keys, err = NewKMS()
+	default:
+		return nil, fmt.Errorf("unknown ENCRYPTION %q", mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{Keys: keys}, nil
+}
+
+// Seal encrypts plaintext under a new data key
+func (s *Sealer) Seal(ctx context.Context, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := s.Keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot wrap the data key: %w", err)
+	}
+	hdr, err := json.Marshal(header{Alg: alg, KeyID: keyID, DataKey: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// magic, header length, header, nonce, ciphertext
+	out := make([]byte, 0, len(magic)+4+len(hdr)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, magic...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(hdr)))
+	out = append(out, hdr...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, hdr), nil
+}
+
+// Open decrypts an object sealed by Seal
+func (s *Sealer) Open(ctx context.Context, sealed []byte) ([]byte, error) {
+	if !IsSealed(sealed) {
+		return nil, errors.New("not a sealed object")
+	}
+	rest := sealed[len(magic):]
+	if len(rest) < 4 {
+		return nil, errors.New("truncated sealed object")
+	}
+	n := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	if uint64(len(rest)) < uint64(n) {
+		return nil, errors.New("truncated sealed object")
+	}
+	hdr, rest := rest[:n], rest[n:]
+	var h header
+	if err := json.Unmarshal(hdr, &h); err != nil {
+		return nil, fmt.Errorf("invalid header of the sealed object: %w", err)
+	}
+	if h.Alg != alg {
+		return nil, fmt.Errorf("unsupported algorithm %q", h.Alg)
+	}
+	dataKey, err := s.Keys.Unwrap(ctx, h.KeyID, h.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap the data key of %s: %w", h.KeyID, err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("truncated sealed object")
+	}
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, hdr)
+	if err != nil {
+		return nil, errors.New("the sealed object is corrupted or was tampered with")
+	}
+	return plaintext, nil
+}
+
+// Ping checks the keys can be reached, when they are remote
+func (s *Sealer) Ping(ctx context.Context) error {
+	if p, ok := s.Keys.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// IsSealed reports whether data was sealed by a Sealer
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,248 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// keyFile writes a new key to a file of dir, base64 encoded
+func keyFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fileSealer returns the sealer of ENCRYPTION=file with the key files
+func fileSealer(t *testing.T, current string, old ...string) *Sealer {
+	t.Helper()
+	t.Setenv("ENCRYPTION", "file")
+	t.Setenv("ENCRYPTION_KEY_FILE", current)
+	t.Setenv("ENCRYPTION_OLD_KEY_FILES", strings.Join(old, ","))
+	s, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSealOpen(t *testing.T) {
+	s := fileSealer(t, keyFile(t, t.TempDir(), "key"))
+	ctx := context.Background()
+	plaintext := []byte("What are your opening hours?")
+
+	sealed, err := s.Seal(ctx, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || bytes.Contains(sealed, plaintext) {
+		t.Fatal("the sealed object is not sealed")
+	}
+	again, err := s.Seal(ctx, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Error("two seals of the same text are equal")
+	}
+	opened, err := s.Open(ctx, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("got %q, want %q", opened, plaintext)
+	}
+
+	empty, err := s.Seal(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := s.Open(ctx, empty); err != nil || len(opened) != 0 {
+		t.Errorf("empty object: got %q, %v", opened, err)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	s := fileSealer(t, keyFile(t, t.TempDir(), "key"))
+	ctx := context.Background()
+	sealed, err := s.Seal(ctx, []byte("card 4111 1111 1111 1111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdrEnd := len(magic) + 4 + bytes.IndexByte(sealed[len(magic)+4:], '}') + 1
+
+	for name, tamper := range map[string]func([]byte) []byte{
+		"ciphertext": func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+		"nonce":      func(b []byte) []byte { b[hdrEnd] ^= 1; return b },
+		"header":     func(b []byte) []byte { return bytes.Replace(b, []byte(`"alg"`), []byte(`"alx"`), 1) },
+		"truncated":  func(b []byte) []byte { return b[:hdrEnd+5] },
+		"magic":      func(b []byte) []byte { b[0] = 'X'; return b },
+		"length":     func(b []byte) []byte { b[len(magic)] = 0xff; return b },
+	} {
+		if _, err := s.Open(ctx, tamper(bytes.Clone(sealed))); err == nil {
+			t.Errorf("%s tampered with: opened", name)
+		}
+	}
+}
+
+func TestOpenWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	sealed, err := fileSealer(t, keyFile(t, dir, "a")).Seal(ctx, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := fileSealer(t, keyFile(t, dir, "b"))
+	if _, err := other.Open(ctx, sealed); err == nil || !strings.Contains(err.Error(), "ENCRYPTION_OLD_KEY_FILES") {
+		t.Errorf("unknown key: got %v", err)
+	}
+
+	// A key claiming the ID of another one cannot unwrap its data keys
+	keys := other.Keys.(*FileKeys)
+	var h header
+	n := len(magic) + 4
+	hdrLen := int(sealed[len(magic)])<<24 | int(sealed[len(magic)+1])<<16 | int(sealed[len(magic)+2])<<8 | int(sealed[len(magic)+3])
+	if err := json.Unmarshal(sealed[n:n+hdrLen], &h); err != nil {
+		t.Fatal(err)
+	}
+	keys.keys[h.KeyID] = keys.keys[keys.current]
+	if _, err := other.Open(ctx, sealed); err == nil {
+		t.Error("wrong key: opened")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	oldKey, newKey := keyFile(t, dir, "old"), keyFile(t, dir, "new")
+	sealed, err := fileSealer(t, oldKey).Seal(ctx, []byte("before the rotation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := fileSealer(t, newKey, oldKey)
+	opened, err := rotated.Open(ctx, sealed)
+	if err != nil || string(opened) != "before the rotation" {
+		t.Fatalf("old object after the rotation: got %q, %v", opened, err)
+	}
+	sealedAfter, err := rotated.Seal(ctx, []byte("after the rotation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new objects are sealed with the new key only
+	if _, err := fileSealer(t, oldKey).Open(ctx, sealedAfter); err == nil {
+		t.Error("new object opened with the old key")
+	}
+	if _, err := fileSealer(t, newKey).Open(ctx, sealedAfter); err != nil {
+		t.Errorf("new object: %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"raw":    key,
+		"hex":    []byte(hex.EncodeToString(key) + "\n"),
+		"base64": []byte(base64.StdEncoding.EncodeToString(key)),
+	} {
+		got, err := ParseKey(data)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("%s: got %x, %v", name, got, err)
+		}
+	}
+	if _, err := ParseKey([]byte("too short")); err == nil {
+		t.Error("short key: no error")
+	}
+	if KeyID(key) == KeyID(make([]byte, 32)) {
+		t.Error("two keys with the same ID")
+	}
+}
+
+// fakeTransit is the transit API of a KMS holding one key
+type fakeTransit struct {
+	mu      sync.Mutex
+	wrapped map[string]string // ciphertext -> plaintext
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "token" {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	var req map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var data map[string]string
+	switch r.URL.Path {
+	case "/v1/transit/encrypt/ivr":
+		ciphertext := "vault:v1:" + hex.EncodeToString([]byte(req["plaintext"]))[:16] + string(rune('a'+len(f.wrapped)))
+		f.wrapped[ciphertext] = req["plaintext"]
+		data = map[string]string{"ciphertext": ciphertext}
+	case "/v1/transit/decrypt/ivr":
+		plaintext, ok := f.wrapped[req["ciphertext"]]
+		if !ok {
+			http.Error(w, "invalid ciphertext", http.StatusBadRequest)
+			return
+		}
+		data = map[string]string{"plaintext": plaintext}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func TestKMS(t *testing.T) {
+	srv := httptest.NewServer(&fakeTransit{wrapped: map[string]string{}})
+	defer srv.Close()
+	t.Setenv("ENCRYPTION", "kms")
+	t.Setenv("KMS_URL", srv.URL+"/")
+	t.Setenv("KMS_TOKEN", "token")
+	s, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := s.Seal(ctx, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := s.Open(ctx, sealed); err != nil || string(opened) != "secret" {
+		t.Errorf("got %q, %v", opened, err)
+	}
+
+	s.Keys.(*KMS).Token = "wrong"
+	if _, err := s.Open(ctx, sealed); err == nil {
+		t.Error("wrong token: opened")
+	}
+	if _, err := s.Keys.Unwrap(ctx, "file:0123", nil); err == nil {
+		t.Error("key of a file: unwrapped by the KMS")
+	}
+}
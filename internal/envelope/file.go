@@ -0,0 +1,122 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FileKeys wraps the data keys with a key read from a file. The older keys
+// still open what they wrapped, so the key can be rotated.
+type FileKeys struct {
+	current string            // ID of the key wrapping the new data keys
+	keys    map[string][]byte // by ID
+}
+
+// NewFileKeys wraps with the key of ENCRYPTION_KEY_FILE, and unwraps with it
+// or the keys of ENCRYPTION_OLD_KEY_FILES (comma separated)
+func NewFileKeys() (*FileKeys, error) {
+	path := os.Getenv("ENCRYPTION_KEY_FILE")
+	if path == "" {
+		return nil, errors.New("ENCRYPTION_KEY_FILE is required with ENCRYPTION=file, create it with: openssl rand -base64 32")
+	}
+	f := &FileKeys{keys: map[string][]byte{}}
+	id, err := f.load(path)
+	if err != nil {
+		return nil, err
+	}
+	f.current = id
+	for _, old := range strings.Split(os.Getenv("ENCRYPTION_OLD_KEY_FILES"), ",") {
+		if old = strings.TrimSpace(old); old != "" {
+			if _, err := f.load(old); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *FileKeys) load(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the key: %w", err)
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return "", fmt.Errorf("key %s: %w", path, err)
+	}
+	id := KeyID(key)
+	f.keys[id] = key
+	return id, nil
+}
+
+func (f *FileKeys) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := wrapKey(f.keys[f.current], f.current, dataKey)
+	return f.current, wrapped, err
+}
+
+func (f *FileKeys) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s, add its file to ENCRYPTION_OLD_KEY_FILES", keyID)
+	}
+	return unwrapKey(key, keyID, wrapped)
+}
+
+// ParseKey reads a 256 bits key written as 32 raw bytes, hex or base64
+func ParseKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("expected a 256 bits key, as 32 bytes, hex or base64")
+}
+
+// KeyID names a key by its fingerprint, the key cannot be derived from it
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("ivr key id\x00"), key...))
+	return "file:" + hex.EncodeToString(sum[:8])
+}
+
+// wrapKey seals dataKey under key, bound to keyID
+func wrapKey(key []byte, keyID string, dataKey []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+func unwrapKey(key []byte, keyID string, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("truncated data key")
+	}
+	dataKey, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, errors.New("wrong key or corrupted data key")
+	}
+	return dataKey, nil
+}
+
+var _ KeyWrapper = (*FileKeys)(nil)
@@ -0,0 +1,107 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// KMS wraps the data keys with a named key of a KMS speaking the transit API
+// of Vault / OpenBao, or cmd/kms, its local stand-in. The key encryption key
+// never leaves the KMS.
+type KMS struct {
+	URL   string // ex : http://vault:8200
+	Token string
+	Key   string // name of the transit key
+
+	client *http.Client
+}
+
+// NewKMS wraps with the key KMS_KEY (ivr by default) of KMS_URL, with the
+// token KMS_TOKEN
+func NewKMS() (*KMS, error) {
+	u := os.Getenv("KMS_URL")
+	if u == "" {
+		return nil, errors.New("KMS_URL is required with ENCRYPTION=kms, ex : http://vault:8200")
+	}
+	key := os.Getenv("KMS_KEY")
+	if key == "" {
+		key = "ivr"
+	}
+	return &KMS{
+		URL:    strings.TrimSuffix(u, "/"),
+		Token:  os.Getenv("KMS_TOKEN"),
+		Key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (k *KMS) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	var res struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}
+	if err := k.do(ctx, "encrypt", k.Key, req, &res); err != nil {
+		return "", nil, err
+	}
+	return "kms:" + k.Key, []byte(res.Data.Ciphertext), nil
+}
+
+func (k *KMS) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	name, ok := strings.CutPrefix(keyID, "kms:")
+	if !ok {
+		return nil, fmt.Errorf("key %s is not a key of the KMS", keyID)
+	}
+	var res struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := k.do(ctx, "decrypt", name, map[string]string{"ciphertext": string(wrapped)}, &res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Data.Plaintext)
+}
+
+// Ping checks the KMS answers, with a key of its own
+func (k *KMS) Ping(ctx context.Context) error {
+	_, _, err := k.Wrap(ctx, make([]byte, 32))
+	return err
+}
+
+func (k *KMS) do(ctx context.Context, op string, key string, body any, res any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/v1/transit/%s/%s", k.URL, op, url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.Token != "" {
+		req.Header.Set("X-Vault-Token", k.Token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("KMS %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("KMS %s: %s", op, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+var _ KeyWrapper = (*KMS)(nil)
@@ -0,0 +1,314 @@
+package fakeari
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CyCoreSystems/ari/v5"
+	"golang.org/x/net/websocket"
+)
+
+// Call describes an incoming call entering the Stasis application
+type Call struct {
+	ID           string // channel ID, ex : "1700000000.1"
+	CallerNumber string
+	CallerName   string
+	Exten        string // dialed number
+	Args         []string
+}
+
+// StartCall creates the channel and sends StasisStart
+func (s *Server) StartCall(c Call) error {
+	data := ari.ChannelData{
+		ID:     c.ID,
+		Name:   fmt.Sprintf("PJSIP/%s-%s", c.CallerNumber, c.ID),
+		State:  "Ring",
+		Caller: &ari.CallerID{Name: c.CallerName, Number: c.CallerNumber},
+		Dialplan: &ari.DialplanCEP{
+			Context:  "default",
+			Exten:    c.Exten,
+			Priority: 1,
+		},
+	}
+
+	s.mu.Lock()
+	if _, ok := s.channels[c.ID]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("channel %s already exists", c.ID)
+	}
+	s.channels[c.ID] = &channel{data: data}
+	s.mu.Unlock()
+
+	return s.emit(&ari.StasisStart{
+		EventData: eventData(ari.Events.StasisStart),
+		Args:      c.Args,
+		Channel:   data,
+	})
+}
+
+// SendDTMF sends one ChannelDtmfReceived per digit
+func (s *Server) SendDTMF(channelID string, digits string) error {
+	data, err := s.channelData(channelID)
+	if err != nil {
+		return err
+	}
+	for _, d := range digits {
+		err := s.emit(&ari.ChannelDtmfReceived{
+			EventData:  eventData(ari.Events.ChannelDtmfReceived),
+			Channel:    data,
+			Digit:      string(d),
+			DurationMs: 100,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EndCall hangs the caller up: live playbacks and recordings are finished and
+// StasisEnd is sent.
+func (s *Server) EndCall(channelID string) error {
+	if _, err := s.channelData(channelID); err != nil {
+		return err
+	}
+	s.hangup(channelID)
+	return nil
+}
+
+// FinishPlayback sends PlaybackFinished for a live playback
+func (s *Server) FinishPlayback(id string) error {
+	s.mu.Lock()
+	pb, ok := s.playbacks[id]
+	if ok {
+		delete(s.playbacks, id)
+		pb.data.State = "done"
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("playback %s: %w", id, errNotFound)
+	}
+
+	return s.emit(&ari.PlaybackFinished{
+		EventData: eventData(ari.Events.PlaybackFinished),
+		Playback:  pb.data,
+	})
+}
+
+// FinishRecording stores audio under the recording name and sends RecordingFinished.
+// When audio is nil, audio queued with QueueRecording is used, or an empty file.
+func (s *Server) FinishRecording(name string, audio []byte) error {
+	s.mu.Lock()
+	rec, ok := s.recordings[name]
+	if ok {
+		delete(s.recordings, name)
+		rec.data.State = "done"
+		if audio == nil {
+			audio = s.dequeue(rec.channelID)
+		}
+		s.stored[name] = audio
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("recording %s: %w", name, errNotFound)
+	}
+
+	return s.emit(&ari.RecordingFinished{
+		EventData: eventData(ari.Events.RecordingFinished),
+		Recording: rec.data,
+	})
+}
+
+// QueueRecording sets the audio the caller "says" during the next recording
+// started on the channel. The recording finishes by itself after
+// Options.RecordingDuration.
+func (s *Server) QueueRecording(channelID string, audio []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued[channelID] = append(s.queued[channelID], audio)
+}
+
+// StoreRecording adds a stored recording, as if Asterisk had recorded it
+func (s *Server) StoreRecording(name string, audio []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored[name] = audio
+}
+
+// StoredRecording returns the audio of a stored recording
+func (s *Server) StoredRecording(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	audio, ok := s.stored[name]
+	return audio, ok
+}
+
+// LivePlaybacks returns the media URI of the playbacks currently running on a channel
+func (s *Server) LivePlaybacks(channelID string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]string)
+	for id, pb := range s.playbacks {
+		if pb.channelID == channelID {
+			res[id] = pb.data.MediaURI
+		}
+	}
+	return res
+}
+
+// LiveRecordings returns the names of the recordings currently running on a channel
+func (s *Server) LiveRecordings(channelID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []string
+	for name, rec := range s.recordings {
+		if rec.channelID == channelID {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+func (s *Server) channelData(id string) (ari.ChannelData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[id]
+	if !ok {
+		return ari.ChannelData{}, fmt.Errorf("channel %s: %w", id, errNotFound)
+	}
+	return ch.data, nil
+}
+
+// dequeue must be called with s.mu held
+func (s *Server) dequeue(channelID string) []byte {
+	q := s.queued[channelID]
+	if len(q) == 0 {
+		return []byte{}
+	}
+	s.queued[channelID] = q[1:]
+	return q[0]
+}
+
+func (s *Server) startPlayback(channelID, id, media string) ari.PlaybackData {
+	data := ari.PlaybackData{
+		ID:        id,
+		MediaURI:  media,
+		State:     "playing",
+		TargetURI: "channel:" + channelID,
+	}
+	s.mu.Lock()
+	s.playbacks[id] = &playback{data: data, channelID: channelID}
+	s.mu.Unlock()
+
+	s.emit(&ari.PlaybackStarted{
+		EventData: eventData(ari.Events.PlaybackStarted),
+		Playback:  data,
+	})
+	if d := s.opts.PlaybackDuration; d >= 0 {
+		time.AfterFunc(d, func() { s.FinishPlayback(id) })
+	}
+	return data
+}
+
+func (s *Server) startRecording(channelID, name, format string) (ari.LiveRecordingData, error) {
+	if name == "" {
+		return ari.LiveRecordingData{}, fmt.Errorf("recording name is required")
+	}
+	data := ari.LiveRecordingData{
+		Name:      name,
+		Format:    format,
+		State:     "recording",
+		TargetURI: "channel:" + channelID,
+	}
+	s.mu.Lock()
+	s.recordings[name] = &recording{data: data, channelID: channelID}
+	autoFinish := len(s.queued[channelID]) > 0
+	s.mu.Unlock()
+
+	s.emit(&ari.RecordingStarted{
+		EventData: eventData(ari.Events.RecordingStarted),
+		Recording: data,
+	})
+	if autoFinish {
+		time.AfterFunc(s.opts.RecordingDuration, func() { s.FinishRecording(name, nil) })
+	}
+	return data, nil
+}
+
+// hangup finishes everything running on the channel, removes it and sends StasisEnd
+func (s *Server) hangup(channelID string) {
+	var pbs, recs []string
+	s.mu.Lock()
+	ch, ok := s.channels[channelID]
+	for id, pb := range s.playbacks {
+		if pb.channelID == channelID {
+			pbs = append(pbs, id)
+		}
+	}
+	for name, rec := range s.recordings {
+		if rec.channelID == channelID {
+			recs = append(recs, name)
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, id := range pbs {
+		s.FinishPlayback(id)
+	}
+	for _, name := range recs {
+		s.FinishRecording(name, nil)
+	}
+
+	s.mu.Lock()
+	delete(s.channels, channelID)
+	delete(s.queued, channelID)
+	s.mu.Unlock()
+
+	s.emit(&ari.StasisEnd{
+		EventData: eventData(ari.Events.StasisEnd),
+		Channel:   ch.data,
+	})
+}
+
+func eventData(typ string) ari.EventData {
+	return ari.EventData{
+		Type:      typ,
+		Node:      EntityID,
+		Timestamp: ari.DateTime(time.Now()),
+	}
+}
+
+// emit sends the event to every connected application, stamped with its name
+func (s *Server) emit(e ari.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ws, app := range s.conns {
+		data, err := encode(e, app)
+		if err != nil {
+			return err
+		}
+		if err := websocket.Message.Send(ws, string(data)); err != nil {
+			return fmt.Errorf("failed to send %s: %w", e.GetType(), err)
+		}
+	}
+	return nil
+}
+
+// encode marshals the event with its application field set to app
+func encode(e ari.Event, app string) ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	m["application"] = app
+	return json.Marshal(m)
+}
@@ -0,0 +1,447 @@
+// Package fakeari is an in-process fake of the Asterisk REST Interface.
+//
+// It serves the subset of the ARI REST API used by this application, pushes
+// events over the /ari/events websocket and records every command it receives,
+// so the IVR can be driven end-to-end without an Asterisk server:
+//
+//	srv, _ := fakeari.New(fakeari.Options{Application: "app", Username: "u", Password: "p"})
+//	defer srv.Close()
+//	cl, _ := native.Connect(&native.Options{Application: "app", URL: srv.URL(), WebsocketURL: srv.WebsocketURL(), Username: "u", Password: "p"})
+//	go func() {
+//		if err := ivr.Start(ctx, cl, backends, cdr.Discard{}); err != nil {
+//			log.Fatal(err)
+//		}
+//	}()
+//	srv.StartCall(fakeari.Call{ID: "chan-1", CallerNumber: "1000"})
+//	srv.SendDTMF("chan-1", "0")
+package fakeari
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/ari/v5"
+	"golang.org/x/net/websocket"
+)
+
+// EntityID is the Asterisk entity ID reported by the fake server
+const EntityID = "fake-asterisk"
+
+// Options configures the fake server
+type Options struct {
+	Application string // ex : "app", events are stamped with the app of each websocket otherwise
+	Username    string // basic auth is not checked when empty
+	Password    string
+	Addr        string // ex : "127.0.0.1:8088", a random loopback port is used when empty
+
+	// PlaybackDuration is how long a playback lasts before PlaybackFinished is sent.
+	// Zero finishes playbacks right after they start, a negative value never
+	// finishes them automatically (use FinishPlayback).
+	PlaybackDuration time.Duration
+
+	// RecordingDuration is how long a recording with queued audio (see QueueRecording)
+	// lasts before RecordingFinished is sent.
+	RecordingDuration time.Duration
+
+	// RecordingsDir, when set, holds stored recordings too, as <name>.wav files
+	// like the recordings directory of Asterisk
+	RecordingsDir string
+}
+
+// Command is a REST request received by the fake server
+type Command struct {
+	Seq    int // position in the command log, starting at 0
+	Method string
+	Path   string // relative to /ari, ex : /channels/chan-1/play/pb-1
+	Query  url.Values
+	Body   map[string]any
+	Time   time.Time
+}
+
+// Server is the fake ARI server
+type Server struct {
+	opts     Options
+	listener net.Listener
+	http     *http.Server
+
+	mu         sync.Mutex
+	conns      map[*websocket.Conn]string // websocket -> application name
+	connected  chan struct{}
+	commands   []Command
+	newCommand chan struct{}
+	channels   map[string]*channel
+	playbacks  map[string]*playback
+	recordings map[string]*recording
+	stored     map[string][]byte
+	queued     map[string][][]byte // channel ID -> audio for the next recordings
+}
+
+type channel struct {
+	data ari.ChannelData
+}
+
+type playback struct {
+	data      ari.PlaybackData
+	channelID string
+}
+
+type recording struct {
+	data      ari.LiveRecordingData
+	channelID string
+}
+
+// New starts a fake ARI server
+func New(opts Options) (*Server, error) {
+	addr := opts.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+
+	s := &Server{
+		opts:       opts,
+		listener:   l,
+		conns:      make(map[*websocket.Conn]string),
+		connected:  make(chan struct{}),
+		newCommand: make(chan struct{}),
+		channels:   make(map[string]*channel),
+		playbacks:  make(map[string]*playback),
+		recordings: make(map[string]*recording),
+		stored:     make(map[string][]byte),
+		queued:     make(map[string][][]byte),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/ari/events", websocket.Server{Handler: s.serveEvents})
+	mux.HandleFunc("/ari/", s.serveREST)
+	s.http = &http.Server{Handler: s.authenticate(mux)}
+
+	go s.http.Serve(l)
+	return s, nil
+}
+
+// URL returns the ARI base URL, ex : http://127.0.0.1:39123/ari
+func (s *Server) URL() string {
+	return fmt.Sprintf("http://%s/ari", s.listener.Addr())
+}
+
+// WebsocketURL returns the ARI events URL, ex : ws://127.0.0.1:39123/ari/events
+func (s *Server) WebsocketURL() string {
+	return fmt.Sprintf("ws://%s/ari/events", s.listener.Addr())
+}
+
+// Close stops the server and drops every websocket
+func (s *Server) Close() {
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.http.Close()
+}
+
+// WaitConnected blocks until an application has opened the events websocket
+func (s *Server) WaitConnected(ctx context.Context) error {
+	s.mu.Lock()
+	connected := s.connected
+	s.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-connected:
+		return nil
+	}
+}
+
+// Commands returns a copy of every command received so far
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command(nil), s.commands...)
+}
+
+// WaitCommand blocks until a command matching the predicate has been received,
+// including commands received before the call.
+func (s *Server) WaitCommand(ctx context.Context, match func(Command) bool) (Command, error) {
+	seen := 0
+	for {
+		s.mu.Lock()
+		cmds := s.commands[seen:]
+		seen = len(s.commands)
+		notify := s.newCommand
+		for _, c := range cmds {
+			if match(c) {
+				s.mu.Unlock()
+				return c, nil
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Command{}, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Username != "" {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != s.opts.Username || pass != s.opts.Password {
+				http.Error(w, `{"message":"Authentication required"}`, http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) serveEvents(ws *websocket.Conn) {
+	app := s.opts.Application
+	if app == "" {
+		app = ws.Request().URL.Query().Get("app")
+	}
+
+	s.mu.Lock()
+	s.conns[ws] = app
+	select {
+	case <-s.connected:
+	default:
+		close(s.connected)
+	}
+	s.mu.Unlock()
+
+	// Block until the client goes away, nothing is expected from it
+	io.Copy(io.Discard, ws)
+
+	s.mu.Lock()
+	delete(s.conns, ws)
+	s.mu.Unlock()
+}
+
+func (s *Server) record(r *http.Request, path string, body map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, Command{
+		Seq:    len(s.commands),
+		Method: r.Method,
+		Path:   path,
+		Query:  r.URL.Query(),
+		Body:   body,
+		Time:   time.Now(),
+	})
+	close(s.newCommand)
+	s.newCommand = make(chan struct{})
+}
+
+var errNotFound = errors.New("not found")
+
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/ari")
+	body := make(map[string]any)
+	if r.Body != nil {
+		data, _ := io.ReadAll(r.Body)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				http.Error(w, `{"message":"invalid JSON body"}`, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	// Query parameters are accepted as well as a JSON body, like Asterisk does
+	for k, v := range r.URL.Query() {
+		if _, ok := body[k]; !ok && len(v) > 0 {
+			body[k] = v[0]
+		}
+	}
+	s.record(r, path, body)
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	res, err := s.route(r.Method, parts, body)
+
+	switch {
+	case errors.Is(err, errNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"not found"}`))
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+	case res == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if raw, ok := res.([]byte); ok {
+			w.Header().Set("Content-Type", "audio/wav")
+			w.Write(raw)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (s *Server) route(method string, p []string, body map[string]any) (any, error) {
+	str := func(k string) string {
+		v, _ := body[k].(string)
+		return v
+	}
+
+	switch {
+	case method == "GET" && len(p) == 2 && p[0] == "asterisk" && p[1] == "info":
+		return ari.AsteriskInfo{SystemInfo: ari.SystemInfo{EntityID: EntityID, Version: "fake"}}, nil
+
+	case p[0] == "channels" && len(p) >= 2:
+		return s.routeChannel(method, p[1], p[2:], str)
+
+	case p[0] == "playbacks" && len(p) == 2:
+		return s.routePlayback(method, p[1])
+
+	case p[0] == "recordings" && len(p) >= 3 && p[1] == "live":
+		return s.routeLiveRecording(method, p[2], p[3:])
+
+	case p[0] == "recordings" && len(p) >= 2 && p[1] == "stored":
+		return s.routeStoredRecording(method, p[2:], str)
+	}
+
+	// Anything else (bridges, variables, ...) is accepted and only recorded
+	return nil, nil
+}
+
+func (s *Server) routeChannel(method, id string, p []string, str func(string) string) (any, error) {
+	s.mu.Lock()
+	ch, ok := s.channels[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errNotFound
+	}
+
+	switch {
+	case len(p) == 0 && method == "GET":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return ch.data, nil
+
+	case len(p) == 0 && method == "DELETE":
+		s.hangup(id)
+		return nil, nil
+
+	case len(p) == 1 && p[0] == "answer":
+		s.mu.Lock()
+		ch.data.State = "Up"
+		s.mu.Unlock()
+		return nil, nil
+
+	case len(p) == 1 && p[0] == "continue":
+		s.hangup(id)
+		return nil, nil
+
+	case len(p) == 2 && p[0] == "play" && method == "POST":
+		return s.startPlayback(id, p[1], str("media")), nil
+
+	case len(p) == 1 && p[0] == "record" && method == "POST":
+		return s.startRecording(id, str("name"), str("format"))
+	}
+	return nil, nil
+}
+
+func (s *Server) routePlayback(method, id string) (any, error) {
+	s.mu.Lock()
+	pb, ok := s.playbacks[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errNotFound
+	}
+	switch method {
+	case "GET":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return pb.data, nil
+	case "DELETE":
+		return nil, s.FinishPlayback(id)
+	}
+	return nil, nil
+}
+
+func (s *Server) routeLiveRecording(method, name string, p []string) (any, error) {
+	s.mu.Lock()
+	rec, ok := s.recordings[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errNotFound
+	}
+	switch {
+	case method == "GET" && len(p) == 0:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return rec.data, nil
+	case method == "POST" && len(p) == 1 && p[0] == "stop",
+		method == "DELETE" && len(p) == 0:
+		return nil, s.FinishRecording(name, nil)
+	}
+	return nil, nil
+}
+
+func (s *Server) routeStoredRecording(method string, p []string, str func(string) string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(p) == 0 {
+		list := []ari.StoredRecordingData{}
+		for name := range s.stored {
+			list = append(list, ari.StoredRecordingData{Name: name, Format: "wav"})
+		}
+		return list, nil
+	}
+
+	name := p[0]
+	audio, ok := s.stored[name]
+	file := ""
+	if s.opts.RecordingsDir != "" && !strings.ContainsAny(name, `/\`) {
+		file = filepath.Join(s.opts.RecordingsDir, name+".wav")
+	}
+	if !ok && file != "" {
+		if data, err := os.ReadFile(file); err == nil {
+			audio, ok = data, true
+		}
+	}
+	if !ok {
+		return nil, errNotFound
+	}
+
+	switch {
+	case method == "GET" && len(p) == 1:
+		return ari.StoredRecordingData{Name: name, Format: "wav"}, nil
+	case method == "GET" && len(p) == 2 && p[1] == "file":
+		return audio, nil
+	case method == "DELETE" && len(p) == 1:
+		delete(s.stored, name)
+		if file != "" {
+			os.Remove(file)
+		}
+		return nil, nil
+	case method == "POST" && len(p) == 2 && p[1] == "copy":
+		dest := str("destinationRecordingName")
+		if dest == "" {
+			return nil, errors.New("destinationRecordingName is required")
+		}
+		s.stored[dest] = audio
+		return ari.StoredRecordingData{Name: dest, Format: "wav"}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+// ARIConnected fails when the ARI websocket has been disconnected for more than
+// grace (at once when grace is 0)
+func ARIConnected(client ari.Client, grace time.Duration) Check {
+	var mu sync.Mutex
+	lastConnected := time.Now()
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if client.Connected() {
+			lastConnected = time.Now()
+			return nil
+		}
+		if down := time.Since(lastConnected); down > grace {
+			return fmt.Errorf("websocket disconnected for %s", down.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// Writable fails when a file cannot be created in dir
+func Writable(dir string) Check {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthz-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// FreeUDPPort fails when none of the ports can be bound
+func FreeUDPPort(ports []int) Check {
+	return func(ctx context.Context) error {
+		for _, port := range ports {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		}
+		return fmt.Errorf("no free UDP port among %d", len(ports))
+	}
+}
+
+// ParsePorts reads a port ("4002") or an inclusive range of ports ("4002-4010")
+func ParsePorts(s string) ([]int, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	from, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", s)
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+			return nil, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	if from <= 0 || to > 65535 || to < from {
+		return nil, fmt.Errorf("invalid port range %q", s)
+	}
+	ports := make([]int, 0, to-from+1)
+	for p := from; p <= to; p++ {
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
@@ -0,0 +1,145 @@
+// Package health runs the checks served on /healthz (liveness: restart the
+// process when failing) and /readyz (readiness: stop sending calls when failing).
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// checkTimeout bounds every check of a request
+const checkTimeout = 5 * time.Second
+
+// Check returns an error when a dependency is not usable
+type Check func(ctx context.Context) error
+
+// Pinger is implemented by the backends able to check they are reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the liveness and readiness checks
+type Checker struct {
+	mu    sync.Mutex
+	live  []namedCheck
+	ready []namedCheck
+}
+
+// Live adds a liveness check
+func (c *Checker) Live(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.live = append(c.live, namedCheck{name, check})
+}
+
+// Ready adds a readiness check
+func (c *Checker) Ready(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = append(c.ready, namedCheck{name, check})
+}
+
+// Register serves GET /healthz and GET /readyz on mux
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		checks := append([]namedCheck(nil), c.live...)
+		c.mu.Unlock()
+		serve(w, r, checks)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		checks := append([]namedCheck(nil), c.ready...)
+		c.mu.Unlock()
+		serve(w, r, checks)
+	})
+}
+
+type report struct {
+	Status string            `json:"status"` // ok or unavailable
+	Checks map[string]string `json:"checks"` // ok or the error of each check
+}
+
+// serve runs the checks concurrently and answers 503 when one of them fails
+func serve(w http.ResponseWriter, r *http.Request, checks []namedCheck) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	res := report{Status: "ok", Checks: make(map[string]string, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Status = "unavailable"
+				res.Checks[c.name] = err.Error()
+				return
+			}
+			res.Checks[c.name] = "ok"
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if res.Status != "ok" {
+		status = http.StatusServiceUnavailable
+		log.Warn("Health check failed", "path", r.URL.Path, "checks", res.Checks)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Cached runs check at most once per ttl and returns its last result in
+// between, so probes of paid APIs are not sent at every request
+func Cached(check Check, ttl time.Duration) Check {
+	var mu sync.Mutex
+	var last time.Time
+	var lastErr error
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !last.IsZero() && time.Since(last) < ttl {
+			return lastErr
+		}
+		err := check(ctx)
+		// A probe cut by the request or its timeout says nothing about the
+		// backend, the next request probes again
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		last, lastErr = time.Now(), err
+		return err
+	}
+}
+
+// Ping checks a backend when it implements Pinger, and succeeds otherwise
+func Ping(backend any) Check {
+	return func(ctx context.Context) error {
+		p, ok := backend.(Pinger)
+		if !ok {
+			return nil
+		}
+		if err := p.Ping(ctx); err != nil {
+			return fmt.Errorf("unreachable: %w", err)
+		}
+		return nil
+	}
+}
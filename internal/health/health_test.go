@@ -0,0 +1,42 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCached(t *testing.T) {
+	var probes int
+	var result error
+	check := Cached(func(ctx context.Context) error {
+		probes++
+		return result
+	}, time.Hour)
+	ctx := context.Background()
+
+	// A probe cut by the timeout is not cached
+	result = fmt.Errorf("unreachable: %w", context.DeadlineExceeded)
+	if err := check(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+	result = context.Canceled
+	check(ctx)
+	expired, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	result = errors.New("cut")
+	check(expired)
+	if probes != 3 {
+		t.Errorf("got %d probes, want 3", probes)
+	}
+
+	// The other results are
+	result = errors.New("unauthorized")
+	check(ctx)
+	result = nil
+	if err := check(ctx); err == nil || err.Error() != "unauthorized" || probes != 4 {
+		t.Errorf("got %v after %d probes", err, probes)
+	}
+}
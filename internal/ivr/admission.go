@@ -0,0 +1,111 @@
+package ivr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"ari/internal/metrics"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+// callSlots bounds the calls handled at once (MAX_CALLS), a nil value is unlimited
+type callSlots chan struct{}
+
+func newCallSlots() (callSlots, error) {
+	v := os.Getenv("MAX_CALLS")
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid MAX_CALLS %q", v)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return make(callSlots, n), nil
+}
+
+func (s callSlots) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s callSlots) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// overflowMode is what happens to the calls over MAX_CALLS (OVERFLOW): "reject"
+// (default) turns them away like rejectCall, "queue" puts them on hold until a
+// call ends
+func overflowMode() (string, error) {
+	switch mode := os.Getenv("OVERFLOW"); mode {
+	case "", "reject":
+		return "reject", nil
+	case "queue":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown OVERFLOW %q", mode)
+	}
+}
+
+// queueTimeout is how long a call waits on hold for a free slot (QUEUE_TIMEOUT)
+func queueTimeout() (time.Duration, error) {
+	v := os.Getenv("QUEUE_TIMEOUT")
+	if v == "" {
+		return 2 * time.Minute, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid QUEUE_TIMEOUT %q", v)
+	}
+	return d, nil
+}
+
+// queueCall answers the call and plays hold music until a slot is free, at
+// most timeout. It returns false when the call was rejected (timeout or drain)
+// or the caller hung up.
+func queueCall(drainCtx context.Context, ctx context.Context, h *ari.ChannelHandle, data ari.ChannelData, slots callSlots, timeout time.Duration) bool {
+	metrics.QueuedCalls.Inc()
+	defer metrics.QueuedCalls.Dec()
+
+	end := h.Subscribe(ari.Events.StasisEnd)
+	defer end.Cancel()
+
+	logger(ctx).Info("No free call slot, queuing the call", "channel", data.ID)
+	h.Answer()
+	if err := h.MOH(os.Getenv("QUEUE_MOH_CLASS")); err != nil {
+		logger(ctx).Warn("Failed to start the hold music", "channel", data.ID, "err", err)
+	}
+
+	select {
+	case slots <- struct{}{}:
+		h.StopMOH()
+		return true
+	case <-end.Events():
+		metrics.RejectedCalls.WithLabelValues("abandoned").Inc()
+		logger(ctx).Info("Caller hung up while queued", "channel", data.ID)
+		return false
+	case <-time.After(timeout):
+		h.StopMOH()
+		rejectCall(ctx, h, data, "queue_timeout")
+		return false
+	case <-drainCtx.Done():
+		h.StopMOH()
+		rejectCall(ctx, h, data, "draining")
+		return false
+	}
+}
@@ -0,0 +1,277 @@
+package ivr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ari/internal/cdr"
+	"ari/internal/metrics"
+	"ari/internal/tracing"
+	"ari/internal/tts"
+
+	"github.com/CyCoreSystems/ari/v5"
+	"github.com/CyCoreSystems/ari/v5/ext/play"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errEmptyAnswer is returned when the LLM answered nothing to say
+var errEmptyAnswer = errors.New("empty answer")
+
+// streamingEnabled reports whether the answers are spoken sentence by
+// sentence as the LLM writes them (LLM_STREAMING, on by default), rather than
+// once they are complete
+func streamingEnabled() bool {
+	return os.Getenv("LLM_STREAMING") != "off"
+}
+
+// privateText marks ctx private when text holds personal data, which must not
+// outlive the call in the TTS cache: data found by the redactor, or the name
+// and number of the caller
+func privateText(ctx context.Context, backends *Backends, text string) context.Context {
+	private := len(backends.Redactor.Find(text)) > 0
+	if s := sessionFrom(ctx); s != nil {
+		lower := strings.ToLower(text)
+		for _, v := range []string{s.CallerName, s.Caller} {
+			private = private || v != "" && strings.Contains(lower, strings.ToLower(v))
+		}
+	}
+	if private {
+		return tts.Private(ctx)
+	}
+	return ctx
+}
+
+// clip is a synthesized sentence of the answer, <name>.wav of TTSDir
+type clip struct {
+	name string
+	path string
+}
+
+// speaker synthesizes the sentences of an answer while the LLM writes the
+// next ones, and plays them in order as soon as they are ready. The caller
+// skips the rest of the answer with #.
+type speaker struct {
+	ctx      context.Context
+	backends *Backends
+	ch       *ari.ChannelHandle
+	name     string // the sentences are <name>_1, <name>_2..., dated by retention as <name>
+	started  func() // called when the first sentence starts playing
+
+	sentences chan string
+	clips     chan clip
+	done      chan struct{}
+
+	mu         sync.Mutex
+	ttsLatency time.Duration
+	clipsMade  []clip
+	played     bool
+	err        error // the first synthesis error
+
+	skip       context.Context // cancelled when the caller presses #
+	skipAnswer context.CancelFunc
+}
+
+func newSpeaker(ctx context.Context, backends *Backends, ch *ari.ChannelHandle, name string, started func()) *speaker {
+	s := &speaker{
+		ctx:       ctx,
+		backends:  backends,
+		ch:        ch,
+		name:      name,
+		started:   started,
+		sentences: make(chan string, 64),
+		clips:     make(chan clip, 64),
+		done:      make(chan struct{}),
+	}
+	s.skip, s.skipAnswer = context.WithCancel(ctx)
+	go s.synthesize()
+	go s.play()
+	return s
+}
+
+// say queues a sentence of the answer
+func (s *speaker) say(sentence string) error {
+	select {
+	case s.sentences <- sentence:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// wait returns once the queued sentences are played, or skipped. The answer
+// is done: no more sentences can be said.
+func (s *speaker) wait() error {
+	close(s.sentences)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.clipsMade) == 0 {
+		return errEmptyAnswer
+	}
+	return nil
+}
+
+// synthesize turns the sentences into clips, until one fails
+func (s *speaker) synthesize() {
+	defer close(s.clips)
+	n := 0
+	for sentence := range s.sentences {
+		if s.failed() || s.ctx.Err() != nil {
+			continue
+		}
+		n++
+		c := clip{name: fmt.Sprintf("%s_%d", s.name, n)}
+		c.path = fmt.Sprintf("%s/%s.wav", TTSDir(), c.name)
+		start := time.Now()
+		ctx, span := tracing.Tracer.Start(privateText(s.ctx, s.backends, sentence), "tts", trace.WithAttributes(
+			attribute.String("tts.file", c.path),
+			attribute.Int("tts.sentence", n),
+		))
+		err := s.backends.TTS.SynthesizeFile(ctx, sentence, c.path)
+		tracing.End(span, err)
+		metrics.ObserveBackend(metrics.TTS, start, err)
+
+		s.mu.Lock()
+		s.ttsLatency += time.Since(start)
+		if err != nil {
+			s.err = err
+		} else {
+			s.clipsMade = append(s.clipsMade, c)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			logger(s.ctx).Error("Error in TTS", "sentence", n, "err", err)
+			continue
+		}
+		sessionFrom(s.ctx).addFile(c.name)
+		logger(s.ctx).Debug("Sentence synthesized", "file", c.path)
+		s.clips <- c
+	}
+}
+
+func (s *speaker) failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+// play plays the clips in order, the first one stopping the waiting music
+func (s *speaker) play() {
+	defer close(s.done)
+	var span trace.Span
+	defer func() {
+		if span != nil {
+			span.End()
+		}
+	}()
+	for c := range s.clips {
+		if s.skip.Err() != nil {
+			continue
+		}
+		uri, err := mediaURI(s.ctx, c.name)
+		if err != nil {
+			logger(s.ctx).Error("Cannot publish the answer", "file", c.path, "err", err)
+			continue
+		}
+		if span == nil {
+			s.watchSkip()
+			s.started()
+			s.mu.Lock()
+			s.played = true
+			s.mu.Unlock()
+			_, span = tracing.Tracer.Start(s.ctx, "playback", trace.WithAttributes(attribute.String("media.uri", uri)))
+		}
+		logger(s.ctx).Debug("Playing the answer", "media", uri)
+		if err := play.Play(s.skip, s.ch, play.URI(uri)).Err(); err != nil && s.skip.Err() == nil {
+			logger(s.ctx).Error("Error playing the answer", "file", c.path, "err", err)
+		}
+	}
+}
+
+// watchSkip cancels the skip context when the caller presses #
+func (s *speaker) watchSkip() {
+	dtmf := s.ch.Subscribe(ari.Events.ChannelDtmfReceived)
+	go func() {
+		defer dtmf.Cancel()
+		for {
+			select {
+			case <-s.skip.Done():
+				return
+			case evt := <-dtmf.Events():
+				if e, ok := evt.(*ari.ChannelDtmfReceived); ok && e.Digit == "#" {
+					logger(s.ctx).Debug("Answer skipped")
+					s.skipAnswer()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// heard reports whether the caller heard part of the answer
+func (s *speaker) heard() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.played
+}
+
+// waitSkip waits for the caller to press #, at most d; it reports whether they did
+func (s *speaker) waitSkip(d time.Duration) bool {
+	select {
+	case <-s.skip.Done():
+		return s.ctx.Err() == nil
+	case <-time.After(d):
+		return false
+	}
+}
+
+// close stops listening for #
+func (s *speaker) close() {
+	s.skipAnswer()
+}
+
+// join writes the clips one after the other to <name>.wav of TTSDir, the
+// whole answer the caller can listen to again
+func (s *speaker) join(name string) (string, error) {
+	s.mu.Lock()
+	clips := s.clipsMade
+	s.mu.Unlock()
+	var samples []int16
+	for _, c := range clips {
+		data, err := os.ReadFile(c.path)
+		if err != nil {
+			return "", err
+		}
+		clipSamples, rate, err := tts.ReadWAV(data)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", c.path, err)
+		}
+		samples = append(samples, tts.Resample(clipSamples, rate, tts.SampleRate)...)
+	}
+	path := fmt.Sprintf("%s/%s.wav", TTSDir(), name)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if err := tts.WriteWAV(file, samples, tts.SampleRate); err != nil {
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// latency is the time spent synthesizing the sentences
+func (s *speaker) latency() cdr.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return cdr.Duration(s.ttsLatency)
+}
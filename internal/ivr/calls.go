@@ -0,0 +1,162 @@
+package ivr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCallNotFound is returned when no active call has the ID
+var ErrCallNotFound = errors.New("call not found")
+
+// CallInfo describes a call in progress
+type CallInfo struct {
+	CallID  string    `json:"call_id"`
+	Channel string    `json:"channel"`
+	Caller  string    `json:"caller"`
+	Exten   string    `json:"exten"`
+	State   string    `json:"state"` // ringing, answered, menu, recording, processing or answering
+	Menu    string    `json:"menu,omitempty"`
+	Turns   int       `json:"turns"`
+	Start   time.Time `json:"start"`
+}
+
+// callRegistry holds the sessions of the calls handled by callHandl
+type callRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*callSession // by call ID
+}
+
+var calls = &callRegistry{sessions: make(map[string]*callSession)}
+
+func (r *callRegistry) add(s *callSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.ID] = s
+}
+
+func (r *callRegistry) remove(s *callSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, s.ID)
+}
+
+// get finds a call by call ID or channel ID
+func (r *callRegistry) get(id string) (*callSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.sessions[id]; ok {
+		return s, nil
+	}
+	for _, s := range r.sessions {
+		if s.Channel == id {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCallNotFound, id)
+}
+
+// interruptCalls interrupts every call and returns how many there were
+func interruptCalls() int {
+	calls.mu.Lock()
+	sessions := make([]*callSession, 0, len(calls.sessions))
+	for _, s := range calls.sessions {
+		sessions = append(sessions, s)
+	}
+	calls.mu.Unlock()
+
+	for _, s := range sessions {
+		s.interrupt("drain timeout")
+	}
+	return len(sessions)
+}
+
+// ActiveCalls returns the calls in progress, oldest first
+func ActiveCalls() []CallInfo {
+	calls.mu.Lock()
+	res := make([]CallInfo, 0, len(calls.sessions))
+	for _, s := range calls.sessions {
+		res = append(res, s.info())
+	}
+	calls.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Start.Before(res[j].Start) })
+	return res
+}
+
+// GetCall returns a call in progress by call ID or channel ID
+func GetCall(id string) (CallInfo, error) {
+	s, err := calls.get(id)
+	if err != nil {
+		return CallInfo{}, err
+	}
+	return s.info(), nil
+}
+
+// HangupCall hangs up a call in progress
+func HangupCall(ctx context.Context, id string) error {
+	s, err := calls.get(id)
+	if err != nil {
+		return err
+	}
+	logger(withSession(ctx, s)).Warn("Hanging up the call on request")
+	s.hangup("admin hangup")
+	return s.h.Hangup()
+}
+
+// SayToCall synthesizes text and plays it to the caller, after the sound
+// currently playing. It returns once the playback is queued.
+func SayToCall(ctx context.Context, id string, text string) error {
+	s, err := calls.get(id)
+	if err != nil {
+		return err
+	}
+	ctx = withSession(ctx, s)
+
+	name := fmt.Sprintf("admin_%s_%d_tts", s.Channel, time.Now().UnixNano())
+	filePath := filepath.Join(TTSDir(), name+".wav")
+	if err := s.backends.TTS.SynthesizeFile(privateText(ctx, s.backends, text), text, filePath); err != nil {
+		return fmt.Errorf("TTS failed: %w", err)
+	}
+	s.addFile(name)
+	uri, err := mediaURI(ctx, name)
+	if err != nil {
+		return err
+	}
+	logger(ctx).Info("Playing a message on request", "file", filePath)
+	_, err = s.h.Play(name, uri)
+	return err
+}
+
+// FlagCall marks a call in progress for review, its audio is kept when
+// RETENTION_KEEP=flagged
+func FlagCall(ctx context.Context, id string, reason string) error {
+	s, err := calls.get(id)
+	if err != nil {
+		return err
+	}
+	logger(withSession(ctx, s)).Info("Call flagged for review", "reason", reason)
+	s.flag(reason)
+	return nil
+}
+
+// TransferCall sends the call to an extension of the dialplan, in the context
+// the call came from when dialplanContext is empty
+func TransferCall(ctx context.Context, id string, dialplanContext string, exten string, priority int) error {
+	s, err := calls.get(id)
+	if err != nil {
+		return err
+	}
+	if dialplanContext == "" {
+		dialplanContext = s.Context
+	}
+	if priority == 0 {
+		priority = 1
+	}
+	logger(withSession(ctx, s)).Warn("Transferring the call on request", "context", dialplanContext, "exten", exten)
+	return s.transfer(ctx, dialplanContext, exten, priority)
+}
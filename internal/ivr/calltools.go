@@ -0,0 +1,261 @@
+package ivr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"ari/internal/ai"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+var callToolNames = []string{"transfer", "hangup", "hold", "dtmf"}
+
+// RegisterCallTools registers the telephony tools named in CALL_TOOLS, some
+// of transfer, hangup, hold and dtmf, none by default: a caller could talk
+// the model into using them. They act on the call of the context they run
+// in: the transfer, the hangup and the hold wait for the answer of the model
+// to be played.
+func RegisterCallTools(r *ai.Registry) error {
+	var enabled []string
+	if spec := os.Getenv("CALL_TOOLS"); spec != "" && spec != "none" {
+		for _, name := range strings.Split(spec, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(callToolNames, name) {
+				return fmt.Errorf("unknown tool %q in CALL_TOOLS, expected transfer, hangup, hold or dtmf", name)
+			}
+			enabled = append(enabled, name)
+		}
+	}
+
+	var tools []ai.Tool
+	if slices.Contains(enabled, "transfer") {
+		targets, err := transferTargets()
+		if err != nil {
+			return err
+		}
+		// Without destinations there is nowhere to transfer to
+		if len(targets) > 0 {
+			tools = append(tools, transferTool(targets))
+		}
+	}
+	if slices.Contains(enabled, "hangup") {
+		tools = append(tools, endCallTool())
+	}
+	if slices.Contains(enabled, "hold") {
+		tools = append(tools, holdTool())
+	}
+	if slices.Contains(enabled, "dtmf") {
+		tools = append(tools, sendDTMFTool())
+	}
+	for _, t := range tools {
+		if err := r.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferTarget is a destination of the transfer tool, an extension of the
+// dialplan (which can run a queue)
+type transferTarget struct {
+	Name    string // as the model asks for it, ex : "operator"
+	Exten   string
+	Context string // the context of the call when empty
+}
+
+// transferTargets reads TRANSFER_TARGETS, ex : "operator=100,sales=200@sales"
+func transferTargets() ([]transferTarget, error) {
+	var targets []transferTarget
+	spec := os.Getenv("TRANSFER_TARGETS")
+	if spec == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		name, dest, ok := strings.Cut(strings.TrimSpace(item), "=")
+		exten, dialplanContext, _ := strings.Cut(dest, "@")
+		if !ok || name == "" || exten == "" {
+			return nil, fmt.Errorf("invalid TRANSFER_TARGETS entry %q, expected name=exten or name=exten@context", item)
+		}
+		targets = append(targets, transferTarget{Name: name, Exten: exten, Context: dialplanContext})
+	}
+	return targets, nil
+}
+
+// errNoCall is returned by the call tools outside of a call
+var errNoCall = errors.New("no call in progress")
+
+func transferTool(targets []transferTarget) ai.Tool {
+	var names []string
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+	return ai.Tool{
+		Name: "transfer_call",
+		Description: "Transfer the caller to a human or a department, when they ask to talk to someone or when you cannot help them. " +
+			"Tell the caller they are being transferred in your answer, the transfer happens once it is played.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"destination": map[string]any{"type": "string", "enum": names},
+			},
+			"required": []string{"destination"},
+		},
+		Func: func(ctx context.Context, args json.RawMessage) (any, error) {
+			var req struct {
+				Destination string `json:"destination"`
+			}
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, err
+			}
+			i := slices.IndexFunc(targets, func(t transferTarget) bool { return t.Name == req.Destination })
+			if i < 0 {
+				return nil, fmt.Errorf("unknown destination %q, expected one of %s", req.Destination, strings.Join(names, ", "))
+			}
+			target := targets[i]
+			session := sessionFrom(ctx)
+			if session == nil {
+				return nil, errNoCall
+			}
+			session.afterAnswer(func(ctx context.Context) {
+				logger(ctx).Info("Transferring the call for the assistant", "destination", target.Name)
+				if err := session.transfer(ctx, target.Context, target.Exten, 1); err != nil {
+					logger(ctx).Error("Failed to transfer the call", "destination", target.Name, "err", err)
+					session.addError(fmt.Errorf("transfer to %s: %w", target.Name, err))
+					return
+				}
+				// The menus end at once, not when the channel left the app
+				session.cancel()
+			})
+			return map[string]any{"status": "the caller is transferred to " + target.Name + " once your answer is played"}, nil
+		},
+	}
+}
+
+func endCallTool() ai.Tool {
+	return ai.Tool{
+		Name: "end_call",
+		Description: "End the call, when the caller says goodbye or has nothing more to ask. " +
+			"Your answer is played first, then the caller hears goodbye and is hung up.",
+		Func: func(ctx context.Context, args json.RawMessage) (any, error) {
+			session := sessionFrom(ctx)
+			if session == nil {
+				return nil, errNoCall
+			}
+			session.afterAnswer(func(ctx context.Context) {
+				session.hangup("ended by the assistant")
+				StopCall(ctx, session.h)
+				session.cancel()
+			})
+			return map[string]any{"status": "the call ends once your answer is played"}, nil
+		},
+	}
+}
+
+// maxHold bounds the time on hold asked by the model
+const maxHold = 5 * time.Minute
+
+func holdTool() ai.Tool {
+	return ai.Tool{
+		Name:        "hold_call",
+		Description: "Put the caller on hold with music, when they ask for a moment. They come back to the menu after the time on hold.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"seconds": map[string]any{"type": "integer", "description": "time on hold, 30 seconds by default, 300 at most"},
+			},
+		},
+		Func: func(ctx context.Context, args json.RawMessage) (any, error) {
+			var req struct {
+				Seconds int `json:"seconds"`
+			}
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, err
+			}
+			duration := time.Duration(req.Seconds) * time.Second
+			if duration <= 0 {
+				duration = 30 * time.Second
+			}
+			duration = min(duration, maxHold)
+			session := sessionFrom(ctx)
+			if session == nil {
+				return nil, errNoCall
+			}
+			session.afterAnswer(func(ctx context.Context) {
+				holdCall(ctx, session.h, duration)
+			})
+			return map[string]any{"status": fmt.Sprintf("the caller is on hold for %s once your answer is played", duration)}, nil
+		},
+	}
+}
+
+// holdCall plays the music on hold of HOLD_MOH_CLASS for duration, or until
+// the caller presses #
+func holdCall(ctx context.Context, h *ari.ChannelHandle, duration time.Duration) {
+	sessionFrom(ctx).setState("hold", "")
+	dtmf := h.Subscribe(ari.Events.ChannelDtmfReceived)
+	defer dtmf.Cancel()
+	if err := h.MOH(os.Getenv("HOLD_MOH_CLASS")); err != nil {
+		logger(ctx).Error("Failed to put the call on hold", "err", err)
+		return
+	}
+	defer h.StopMOH()
+	logger(ctx).Info("Call on hold", "duration", duration)
+
+	timeout := time.After(duration)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			return
+		case evt := <-dtmf.Events():
+			if e, ok := evt.(*ari.ChannelDtmfReceived); ok && e.Digit == "#" {
+				return
+			}
+		}
+	}
+}
+
+var dtmfDigits = regexp.MustCompile(`^[0-9A-D*#]{1,32}$`)
+
+func sendDTMFTool() ai.Tool {
+	return ai.Tool{
+		Name:        "send_dtmf",
+		Description: "Send DTMF tones on the call, ex : to answer a system the caller is connected to.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"digits": map[string]any{"type": "string", "description": "digits 0-9, *, # and A-D"},
+			},
+			"required": []string{"digits"},
+		},
+		Func: func(ctx context.Context, args json.RawMessage) (any, error) {
+			var req struct {
+				Digits string `json:"digits"`
+			}
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, err
+			}
+			if !dtmfDigits.MatchString(req.Digits) {
+				return nil, fmt.Errorf("invalid digits %q", req.Digits)
+			}
+			session := sessionFrom(ctx)
+			if session == nil {
+				return nil, errNoCall
+			}
+			if err := session.h.SendDTMF(req.Digits, &ari.DTMFOptions{Between: 100 * time.Millisecond}); err != nil {
+				return nil, err
+			}
+			logger(ctx).Info("DTMF sent for the assistant", "digits", len(req.Digits))
+			return map[string]any{"status": "sent"}, nil
+		},
+	}
+}
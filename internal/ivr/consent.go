@@ -0,0 +1,216 @@
+package ivr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"ari/internal/cdr"
+	"ari/internal/metrics"
+
+	"github.com/CyCoreSystems/ari/v5"
+	"github.com/CyCoreSystems/ari/v5/ext/play"
+)
+
+// Consent decisions noted in the call record
+const (
+	ConsentAccepted = "accepted"
+	ConsentRefused  = "refused"
+	ConsentNotified = "notified"  // the notice was played, no answer was asked
+	ConsentNoAnswer = "no answer" // counted as a refusal
+)
+
+// consentMode is how the callers are told they are recorded (CONSENT): "off"
+// (default), "notice" plays consent_notice, "dtmf" asks to press 1 to accept
+// or 2 to refuse (consent_dtmf), "speech" asks to say yes or no
+// (consent_speech)
+func consentMode() (string, error) {
+	switch mode := os.Getenv("CONSENT"); mode {
+	case "", "off":
+		return "off", nil
+	case "notice", "dtmf", "speech":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown CONSENT %q, expected off, notice, dtmf or speech", mode)
+	}
+}
+
+// askConsent plays the recording notice before the caller is recorded and,
+// depending on CONSENT, asks for their consent. It notes the decision in the
+// call record and returns whether the call may go on; the refusing callers
+// have been routed away by then.
+func askConsent(ctx context.Context, client ari.Client, h *ari.ChannelHandle, data ari.ChannelData, backends *Backends) bool {
+	mode, _ := consentMode()
+	if mode == "off" {
+		return true
+	}
+	session := sessionFrom(ctx)
+	session.setState("consent", "")
+
+	consent := &cdr.Consent{Method: mode}
+	switch mode {
+	case "notice":
+		PlaySound(ctx, h, promptURI(ctx, "consent_notice"))
+		consent.Decision = ConsentNotified
+	case "dtmf":
+		consent.Decision, consent.Input = consentByDTMF(ctx, h)
+	case "speech":
+		consent.Decision, consent.Input = consentBySpeech(ctx, client, h, backends)
+	}
+	if ctx.Err() != nil {
+		// The caller hung up before answering
+		consent.Decision = ConsentNoAnswer
+	}
+	consent.Time = time.Now()
+	session.setConsent(consent)
+	metrics.Consent.WithLabelValues(consent.Decision).Inc()
+	logger(ctx).Info("Consent", "decision", consent.Decision, "method", mode)
+
+	if consent.Decision == ConsentAccepted || consent.Decision == ConsentNotified {
+		return true
+	}
+	if ctx.Err() == nil {
+		refuseConsent(ctx, h, data)
+	}
+	return false
+}
+
+// consentByDTMF asks to press 1 to accept or 2 to refuse, returning the
+// decision and the digit pressed
+func consentByDTMF(ctx context.Context, h *ari.ChannelHandle) (string, string) {
+	res, err := play.Prompt(ctx, h,
+		play.URI(promptURI(ctx, "consent_dtmf")),
+		play.MatchDiscrete([]string{"1", "2"}),
+		play.Replays(2)).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			logger(ctx).Error("Failed to ask for consent", "err", err)
+			sessionFrom(ctx).addError(fmt.Errorf("consent: %w", err))
+		}
+		return ConsentNoAnswer, ""
+	}
+	switch res.DTMF {
+	case "1":
+		return ConsentAccepted, res.DTMF
+	case "2":
+		return ConsentRefused, res.DTMF
+	}
+	return ConsentNoAnswer, res.DTMF
+}
+
+// consentBySpeech asks to say yes or no, twice when the answer is unclear,
+// returning the decision and the masked transcript. The short recording of
+// the answer is deleted once transcribed.
+func consentBySpeech(ctx context.Context, client ari.Client, h *ari.ChannelHandle, backends *Backends) (string, string) {
+	var transcript string
+	for attempt := 0; attempt < 2 && ctx.Err() == nil; attempt++ {
+		if err := PlaySound(ctx, h, promptURI(ctx, "consent_speech")); err != nil {
+			return ConsentNoAnswer, ""
+		}
+		name := fmt.Sprintf("consent_%s_%d", h.ID(), time.Now().Unix())
+		audio, err := recordAnswer(ctx, h, name)
+		if err == nil {
+			transcript, err = backends.STT.Transcribe(ctx, bytes.NewReader(audio))
+		}
+		if err := client.StoredRecording().Delete(ari.NewKey(ari.StoredRecordingKey, name)); err != nil {
+			logger(ctx).Warn("Failed to delete the consent recording", "recording", name, "err", err)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logger(ctx).Error("Failed to hear the consent", "err", err)
+				sessionFrom(ctx).addError(fmt.Errorf("consent: %w", err))
+			}
+			return ConsentNoAnswer, ""
+		}
+		transcript = backends.Redactor.Mask(transcript)
+		logger(ctx).Info("Consent answer", "transcript", transcript)
+		if decision := parseConsent(transcript); decision != "" {
+			return decision, transcript
+		}
+	}
+	return ConsentNoAnswer, transcript
+}
+
+// recordAnswer records a short answer of the caller as the stored recording
+// name and returns its audio
+func recordAnswer(ctx context.Context, h *ari.ChannelHandle, name string) ([]byte, error) {
+	rec, err := h.StageRecord(name, &ari.RecordingOptions{
+		Format:      "wav",
+		MaxDuration: 5 * time.Second,
+		MaxSilence:  2 * time.Second,
+		Exists:      "overwrite",
+		Beep:        true,
+		Terminate:   "#",
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Subscribe before starting, the answer can be over before we listen
+	finished := rec.Subscribe(ari.Events.RecordingFinished, ari.Events.RecordingFailed)
+	defer finished.Cancel()
+	if err := rec.Exec(); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		rec.Stop()
+		return nil, ctx.Err()
+	case evt := <-finished.Events():
+		if _, ok := evt.(*ari.RecordingFailed); ok {
+			return nil, fmt.Errorf("recording %s failed", name)
+		}
+	}
+	return downloadRecordingFromARI(ctx, name)
+}
+
+var (
+	// consentIdioms accept the recording despite their negation, ex: "no
+	// problem", "I don't mind", "why not"
+	consentIdioms = regexp.MustCompile(`(?i)\b(no problem|no worries|why not|(don'?t|do not) mind)\b`)
+	// unsureWords neither accept nor refuse, ex: "I'm not sure"
+	unsureWords = regexp.MustCompile(`(?i)\bnot (so |really )?sure\b`)
+	refuseWords = regexp.MustCompile(`(?i)\b(no|nope|nah|non|refuse|disagree|(don'?t|do not|not) (agree|accept|consent|want|think so)|not (ok|okay))\b`)
+	acceptWords = regexp.MustCompile(`(?i)\b(yes|yeah|yep|sure|okay|ok|agree|accept|oui)\b`)
+)
+
+// parseConsent tells whether the transcript accepts or refuses, "" when it is
+// unclear. A refusal wins, "no I don't agree" is not a yes, but only a negated
+// answer refuses: "sure, no problem" is.
+func parseConsent(transcript string) string {
+	transcript = unsureWords.ReplaceAllString(consentIdioms.ReplaceAllString(transcript, "yes"), "")
+	switch {
+	case refuseWords.MatchString(transcript):
+		return ConsentRefused
+	case acceptWords.MatchString(transcript):
+		return ConsentAccepted
+	}
+	return ""
+}
+
+// refuseConsent routes a caller who did not consent: the call continues in
+// the dialplan at CONSENT_EXTEN (in CONSENT_CONTEXT, the context of the call
+// by default), to an operator or a path without recording, when set. Else the
+// caller hears consent_refused and is hung up.
+func refuseConsent(ctx context.Context, h *ari.ChannelHandle, data ari.ChannelData) {
+	if exten := os.Getenv("CONSENT_EXTEN"); exten != "" {
+		dialplanContext := os.Getenv("CONSENT_CONTEXT")
+		if dialplanContext == "" && data.Dialplan != nil {
+			dialplanContext = data.Dialplan.Context
+		}
+		session := sessionFrom(ctx)
+		session.setTransferred(true)
+		err := h.Continue(dialplanContext, exten, 1)
+		if err == nil {
+			logger(ctx).Info("Caller without consent sent to the dialplan", "context", dialplanContext, "exten", exten)
+			session.hangup("no consent, continued in the dialplan")
+			return
+		}
+		session.setTransferred(false)
+		logger(ctx).Error("Failed to continue the call without consent in the dialplan", "err", err)
+	}
+	PlaySound(ctx, h, promptURI(ctx, "consent_refused"))
+	sessionFrom(ctx).hangup("no consent")
+}
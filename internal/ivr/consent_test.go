@@ -0,0 +1,34 @@
+package ivr
+
+import "testing"
+
+func TestParseConsent(t *testing.T) {
+	for transcript, want := range map[string]string{
+		"Yes.":                    ConsentAccepted,
+		"Okay, go ahead":          ConsentAccepted,
+		"yes, I don't mind":       ConsentAccepted,
+		"sure, no problem":        ConsentAccepted,
+		"I don't see why not":     ConsentAccepted,
+		"I do not mind":           ConsentAccepted,
+		"No worries":              ConsentAccepted,
+		"oui":                     ConsentAccepted,
+		"No.":                     ConsentRefused,
+		"no I don't agree":        ConsentRefused,
+		"I do not accept":         ConsentRefused,
+		"I don't want to":         ConsentRefused,
+		"I don't think so":        ConsentRefused,
+		"that's not okay":         ConsentRefused,
+		"I refuse":                ConsentRefused,
+		"yes... actually no":      ConsentRefused,
+		"nope":                    ConsentRefused,
+		"":                        "",
+		"What are your hours?":    "",
+		"I don't know":            "",
+		"nobody told me about it": "",
+		"I'm not sure":            "",
+	} {
+		if got := parseConsent(transcript); got != want {
+			t.Errorf("%q: got %q, want %q", transcript, got, want)
+		}
+	}
+}
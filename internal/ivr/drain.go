@@ -0,0 +1,97 @@
+package ivr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ari/internal/metrics"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+// draining is set once the instance stopped taking new calls
+var draining atomic.Bool
+
+// Draining reports whether the instance is shutting down and refuses new calls
+func Draining() bool {
+	return draining.Load()
+}
+
+// drainTimeout is how long active calls may go on after a shutdown request (DRAIN_TIMEOUT)
+func drainTimeout() (time.Duration, error) {
+	v := os.Getenv("DRAIN_TIMEOUT")
+	if v == "" {
+		return 5 * time.Minute, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid DRAIN_TIMEOUT %q", v)
+	}
+	return d, nil
+}
+
+// rejectCall turns a call away: it continues in the dialplan at BUSY_EXTEN
+// (in BUSY_CONTEXT, the context of the call by default) when set, or hears
+// BUSY_SOUND and is hung up
+func rejectCall(ctx context.Context, h *ari.ChannelHandle, data ari.ChannelData, reason string) {
+	metrics.RejectedCalls.WithLabelValues(reason).Inc()
+	logger(ctx).Warn("Rejecting call", "channel", data.ID, "reason", reason)
+
+	if exten := os.Getenv("BUSY_EXTEN"); exten != "" {
+		dialplanContext := os.Getenv("BUSY_CONTEXT")
+		if dialplanContext == "" && data.Dialplan != nil {
+			dialplanContext = data.Dialplan.Context
+		}
+		err := h.Continue(dialplanContext, exten, 1)
+		if err == nil {
+			return
+		}
+		logger(ctx).Error("Failed to continue the rejected call in the dialplan", "channel", data.ID, "err", err)
+	}
+
+	sound := os.Getenv("BUSY_SOUND")
+	if sound == "" {
+		sound = "sound:tt-allbusy"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	h.Answer()
+	PlaySound(ctx, h, sound)
+	h.Hangup()
+}
+
+// drain waits for the active calls to end. At the deadline, the remaining
+// calls are interrupted: they hear goodbye and are hung up.
+func drain(calls *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		calls.Wait()
+		close(done)
+	}()
+
+	ctx := context.Background()
+	deadline := time.After(timeout)
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+
+	logger(ctx).Warn("Draining, waiting for the active calls to end", "calls", len(ActiveCalls()), "timeout", timeout)
+	for {
+		select {
+		case <-done:
+			logger(ctx).Info("Drained, every call ended")
+			return
+		case <-progress.C:
+			logger(ctx).Info("Draining", "calls", len(ActiveCalls()))
+		case <-deadline:
+			remaining := interruptCalls()
+			logger(ctx).Warn("Drain timeout, hanging up the remaining calls", "calls", remaining)
+			<-done
+			logger(ctx).Info("Drained, every call ended")
+			return
+		}
+	}
+}
@@ -2,18 +2,23 @@ package ivr
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"ari/internal/logging"
+	"ari/internal/metrics"
+
 	"github.com/CyCoreSystems/ari/v5"
-	"github.com/charmbracelet/log"
 )
 
 func DTMFHandl(mainCtx context.Context,
-	sound string, client ari.Client,
+	menu string, client ari.Client,
 	ch *ari.ChannelHandle,
 	actions map[string]ChannelHandler,
 	listDigOpt []string,
 ) {
+	mainCtx = logging.With(mainCtx, "menu", menu)
+	session := sessionFrom(mainCtx)
 
 	sub := client.Bus().Subscribe(nil, "RecordingFinished")
 	defer sub.Cancel()
@@ -26,24 +31,29 @@ func DTMFHandl(mainCtx context.Context,
 		case <-mainCtx.Done():
 			return
 		default:
-
-			if res, er := promptSound(mainCtx, ch, sound, listDigOpt, 3); er == nil {
+			session.setState("menu", menu)
+			if res, er := promptSound(mainCtx, ch, promptURI(mainCtx, menu), listDigOpt, 3); er == nil {
+				if res.DTMF != "" {
+					metrics.DTMFSelections.WithLabelValues(menu, res.DTMF).Inc()
+					session.selectMenu(menu, res.DTMF)
+				}
 
 				if action, ok := actions[res.DTMF]; ok {
 					if err := action(mainCtx, ch); err != nil {
-						log.Error("Error executing action for DTMF digit", "Digit", res.DTMF, "Error", err)
+						logger(mainCtx).Error("Error executing action for DTMF digit", "digit", res.DTMF, "err", err)
+						session.addError(fmt.Errorf("menu %s, digit %s: %w", menu, res.DTMF, err))
 					}
 					if res.DTMF == "1" {
 						for evts := range sub.Events() {
 							if evt, ok := evts.(*ari.RecordingFinished); ok {
-								log.Infof("Recording finished: %s", evt.Recording.Name)
-								log.Info("Should switch on another function")
+								logger(mainCtx).Info("Recording finished", "recording", evt.Recording.Name)
+								logger(mainCtx).Info("Should switch on another function")
 								return
 							}
 
 						}
 					} else {
-						log.Info("Action terminated")
+						logger(mainCtx).Info("Action terminated")
 						return
 					}
 				} else if res.DTMF == "#" {
@@ -54,12 +64,15 @@ func DTMFHandl(mainCtx context.Context,
 					continue
 
 				} else {
-					log.Warn("No action defined for this DTMF digit", "Digit", res.DTMF)
+					logger(mainCtx).Warn("No action defined for this DTMF digit", "digit", res.DTMF)
 				}
 
 				// }
 			} else {
-				log.Error("Error during prompt sound", "Error", er)
+				logger(mainCtx).Error("Error during prompt sound", "err", er)
+				if mainCtx.Err() == nil {
+					session.addError(fmt.Errorf("menu %s: %w", menu, er))
+				}
 				return
 			}
 		}
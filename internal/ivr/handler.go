@@ -4,44 +4,151 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"ari/internal/ai"
+	"ari/internal/cdr"
+	"ari/internal/knowledge"
+	"ari/internal/logging"
+	"ari/internal/media"
+	"ari/internal/metrics"
+	"ari/internal/policy"
+	"ari/internal/prompts"
+	"ari/internal/redact"
+	"ari/internal/retention"
 	"ari/internal/stt"
+	"ari/internal/tracing"
 	"ari/internal/tts"
 
 	"github.com/CyCoreSystems/ari/v5"
-	"github.com/charmbracelet/log"
-	apiPrerecordedInterfaces "github.com/deepgram/deepgram-go-sdk/pkg/api/prerecorded/v1/interfaces"
-	apiSpeakResponseInterfaces "github.com/deepgram/deepgram-go-sdk/pkg/api/speak/v1/rest/interfaces"
+	"github.com/CyCoreSystems/ari/v5/ext/play"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Channel variables exposing the last turn of the conversation, so tools like
+// cmd/callsim (or the dialplan) can inspect what the bot heard and answered.
+const (
+	TranscriptVariable = "IVR_TRANSCRIPT"
+	AnswerVariable     = "IVR_ANSWER"
 )
 
 type ChannelHandler func(ctx context.Context, h *ari.ChannelHandle) error
 type AfterRecordHandler func(ctx context.Context, h *ari.ChannelHandle, filename string) error
 
-func Start(ctx context.Context, client ari.Client) {
+// Backends are the speech and language services used during the calls, and
+// what becomes of their audio
+type Backends struct {
+	STT     stt.Transcriber
+	TTS     tts.Synthesizer
+	LLM     ai.Model
+	Prompts *prompts.Prompts // menu prompts rendered with TTS, nil for the pre-recorded sounds
+	Media   media.Publisher  // plays the generated audio, from the shared directory when nil
+	// Retention archives or deletes the audio of the ended calls, nil keeps it in place
+	Retention *retention.Retention
+	// Redactor masks the personal data of the transcripts and answers, nil keeps them
+	Redactor *redact.Redactor
+	// Tools are the functions the LLM can call while answering, nil for none
+	Tools *ai.Registry
+	// Knowledge is searched for passages answering the questions, nil for none
+	Knowledge *knowledge.Base
+}
+
+// Start handles the calls entering the Stasis application, at most MAX_CALLS at
+// once. When ctx is cancelled, it drains: new calls are rejected while the
+// active calls go on until they end or DRAIN_TIMEOUT, then Start returns.
+func Start(ctx context.Context, client ari.Client, backends *Backends, records cdr.Store) error {
+	slots, err := newCallSlots()
+	if err != nil {
+		return err
+	}
+	overflow, err := overflowMode()
+	if err != nil {
+		return err
+	}
+	if _, err := consentMode(); err != nil {
+		return err
+	}
+	drainAfter, err := drainTimeout()
+	if err != nil {
+		return err
+	}
+	queueFor, err := queueTimeout()
+	if err != nil {
+		return err
+	}
+
+	// Takes calls again after the drain of a previous Start
+	draining.Store(false)
 	sub := client.Bus().Subscribe(nil, "StasisStart")
 	defer sub.Cancel()
 
+	// Calls are not aborted by the shutdown request, the drain ends them
+	callsCtx := context.WithoutCancel(ctx)
+	var active sync.WaitGroup
+	// The calls rejected during the drain, which may already be waiting on
+	// active, are waited for before returning
+	var rejected sync.WaitGroup
+
+	drained := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		draining.Store(true)
+		drain(&active, drainAfter)
+		close(drained)
+	}()
+
 	for {
 		select {
-		case <-ctx.Done():
-			log.Warn("context cancelled, exiting ...")
-			return
+		case <-drained:
+			rejected.Wait()
+			return nil
 
 		case evt, ok := <-sub.Events():
 			if !ok {
-				log.Warn("event channel closed")
-				return
+				logger(ctx).Warn("event channel closed")
+				return nil
 			}
 
 			if chanHandl, ok := evt.(*ari.StasisStart); ok {
-				log.Infof("Events StasisStart Type = %T", chanHandl)
-				go callHandl(ctx,
-					client.Channel().Get(chanHandl.Key(ari.ChannelKey, chanHandl.Channel.ID)),
-					client,
-				)
-
+				logger(ctx).Debug("StasisStart received", "channel", chanHandl.Channel.ID)
+				metrics.StasisStart.Inc()
+				h := client.Channel().Get(chanHandl.Key(ari.ChannelKey, chanHandl.Channel.ID))
+				if Draining() {
+					rejected.Add(1)
+					go func() {
+						defer rejected.Done()
+						rejectCall(callsCtx, h, chanHandl.Channel, "draining")
+					}()
+					continue
+				}
+				if !slots.tryAcquire() {
+					if overflow != "queue" {
+						active.Add(1)
+						go func() {
+							defer active.Done()
+							rejectCall(callsCtx, h, chanHandl.Channel, "overflow")
+						}()
+						continue
+					}
+					active.Add(1)
+					go func() {
+						defer active.Done()
+						if queueCall(ctx, callsCtx, h, chanHandl.Channel, slots, queueFor) {
+							defer slots.release()
+							callHandl(callsCtx, h, chanHandl.Channel, client, backends, records)
+						}
+					}()
+					continue
+				}
+				active.Add(1)
+				go func() {
+					defer active.Done()
+					defer slots.release()
+					callHandl(callsCtx, h, chanHandl.Channel, client, backends, records)
+				}()
 			}
 		}
 	}
@@ -49,43 +156,82 @@ func Start(ctx context.Context, client ari.Client) {
 
 func callHandl(mainCtx context.Context,
 	h *ari.ChannelHandle,
+	data ari.ChannelData,
 	client ari.Client,
+	backends *Backends,
+	records cdr.Store,
 ) {
-	h.Answer()
-	time.Sleep(2 * time.Second)
-	defer h.Hangup()
+	session := newCallSession(h, data, backends)
+	mainCtx = withSession(mainCtx, session)
+	defer saveRecord(mainCtx, records, session)
 
 	mainCtx, cancel := context.WithCancel(mainCtx)
 	defer cancel()
+	session.cancel = cancel
 
-	log.Info("Runnign app", "Channel", h.ID())
+	calls.add(session)
+	defer calls.remove(session)
 
-	end := h.Subscribe(ari.Events.StasisEnd)
+	// Subscribe before answering, the caller can hang up at any time
+	end := h.Subscribe(ari.Events.StasisEnd, ari.Events.ChannelHangupRequest)
 	defer end.Cancel()
 
 	//End the app when the channel goes away
-	go func() {
-		<-end.Events()
-		cancel()
+	go watchHangup(end, session, cancel)
+
+	h.Answer()
+	session.answered()
+	session.setState("answered", "")
+	select {
+	case <-mainCtx.Done():
+	case <-time.After(2 * time.Second):
+	}
+	defer func() {
+		// A transferred channel belongs to the dialplan now
+		if session.isTransferred() {
+			return
+		}
+		if session.isInterrupted() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(mainCtx), 10*time.Second)
+			PlaySound(ctx, h, promptURI(ctx, "ari_goodbye"))
+			cancel()
+		}
+		h.Hangup()
 	}()
-	log.Info("print channel handler ", "channelHandler", h)
+
+	metrics.ActiveCalls.Inc()
+	defer metrics.ActiveCalls.Dec()
+
+	// Root span of the call, every stage of the pipeline is a child of it
+	mainCtx, span := tracing.Tracer.Start(mainCtx, "call",
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("channel.id", h.ID()),
+			attribute.String("call.id", session.ID),
+		),
+	)
+	defer span.End()
+
+	logger(mainCtx).Info("Call started", "exten", session.Exten)
+
+	// Nothing is recorded before the caller was told, and agreed when asked
+	if !askConsent(mainCtx, client, h, data, backends) {
+		return
+	}
 
 	recFilename := fmt.Sprintf("msg_%s_%d", h.ID(), time.Now().Unix())
 
 	DTMFHandl(mainCtx,
-		"sound:welcome-ari",
+		"welcome-ari",
 		client,
 		h,
 		firstRecord(recFilename), []string{"1", "0", "#"}) //First record wiht welcome message
 
-	var recResBody apiPrerecordedInterfaces.PreRecordedResponse
-	var speakResBody apiSpeakResponseInterfaces.SpeakResponse
-
 	DTMFHandl(mainCtx,
-		"sound:after_recording",
+		"after_recording",
 		client,
 		h,
-		secondRecord(recFilename, &recResBody, &speakResBody, h),
+		secondRecord(recFilename, backends, h),
 		[]string{"1", "2", "3", "0", "#"},
 	) //Second record with listen option and another message
 
@@ -94,15 +240,15 @@ func callHandl(mainCtx context.Context,
 	for {
 		select {
 		case <-mainCtx.Done():
-			log.Info("Main context done, exiting DTMF handler loop", "Channel", h.ID())
+			logger(mainCtx).Info("Call ended")
 			return
 
 		default:
 			DTMFHandl(mainCtx,
-				"sound:after_recording",
+				"after_recording",
 				client,
 				h,
-				thirdRecord(recFilename, resFilename, &recResBody, &speakResBody, h),
+				thirdRecord(recFilename, resFilename, backends, h),
 				[]string{"1", "2", "3", "4", "0", "#"},
 			) //Third record with listen option both for the request and the response of the request
 			//Also make another request
@@ -111,26 +257,108 @@ func callHandl(mainCtx context.Context,
 
 }
 
+// watchHangup cancels the call when the channel leaves the app, noting the hangup cause
+func watchHangup(sub ari.Subscription, session *callSession, cancel context.CancelFunc) {
+	defer cancel()
+	for evt := range sub.Events() {
+		switch v := evt.(type) {
+		case *ari.ChannelHangupRequest:
+			session.hangup(fmt.Sprintf("caller hangup (cause %d)", v.Cause))
+		case *ari.StasisEnd:
+			metrics.StasisEnd.Inc()
+			if !session.isTransferred() {
+				session.hangup("caller hangup")
+			}
+			return
+		}
+	}
+}
+
+// saveRecord applies the retention policy to the audio of the call once it
+// ended, then stores its record
+func saveRecord(ctx context.Context, records cdr.Store, session *callSession) {
+	// The call context is cancelled by now
+	ctx = context.WithoutCancel(ctx)
+
+	session.hangup("ivr hangup")
+	record := session.finish()
+	archiveCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	if err := session.backends.Retention.AfterCall(archiveCtx, session.audio(record)); err != nil {
+		logger(ctx).Error("Failed to archive the call audio, it is kept in place", "err", err)
+	}
+	cancel()
+
+	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := records.Save(ctx, record); err != nil {
+		logger(ctx).Error("Failed to save the call record", "err", err)
+	}
+}
+
 func StopCall(ctx context.Context, h *ari.ChannelHandle) error {
-	err := PlaySound(ctx, h, "sound:ari_goodbye")
-	log.Info("Stopping call", "Channel", h.ID())
+	err := PlaySound(ctx, h, promptURI(ctx, "ari_goodbye"))
+	logger(ctx).Info("Stopping call")
+	sessionFrom(ctx).hangup("ivr hangup")
 	h.Hangup()
 	return err
 }
 
+func setCallVariable(ctx context.Context, h *ari.ChannelHandle, name string, value string) {
+	if err := h.SetVariable(name, value); err != nil {
+		logger(ctx).Warn("Failed to set channel variable", "variable", name, "err", err)
+	}
+}
+
 func DoNothing(ctx context.Context, h *ari.ChannelHandle) error {
-	log.Info("Doing nothing for Channel", "Channel", h.ID())
+	logger(ctx).Info("Doing nothing for Channel")
 	return nil
 }
 
+// TTSDir is the directory shared with Asterisk where answers are written (TTS_DIR)
+func TTSDir() string {
+	return tts.Dir()
+}
+
 func ValidateSend(filename string,
-	recResBody *apiPrerecordedInterfaces.PreRecordedResponse,
-	speakResBody *apiSpeakResponseInterfaces.SpeakResponse,
+	backends *Backends,
 	ch *ari.ChannelHandle,
 ) ChannelHandler {
-	return func(ctx context.Context, h *ari.ChannelHandle) error {
+	return func(ctx context.Context, h *ari.ChannelHandle) (err error) {
+		validated := time.Now()
+		session := sessionFrom(ctx)
+		session.setState("processing", "")
+		turnNumber := session.nextTurn()
+		ctx = logging.With(ctx, "turn", turnNumber)
+		ctx, served := policy.TrackServed(ctx)
+		record := cdr.Turn{Number: turnNumber, Start: validated, Recording: filename}
+		defer func() {
+			if err != nil {
+				record.Error = err.Error()
+			}
+			record.STTProvider = served.Provider(metrics.STT)
+			record.LLMProvider = served.Provider(metrics.LLM)
+			record.TTSProvider = served.Provider(metrics.TTS)
+			session.addTurn(record)
+		}()
+		ctx, turn := tracing.Tracer.Start(ctx, "turn", trace.WithAttributes(
+			attribute.String("recording.name", filename),
+			attribute.Int("turn", turnNumber),
+		))
+		defer func() { tracing.End(turn, err) }()
+		// The transfer, hangup or hold asked by the LLM, once the caller heard why
+		defer session.runAfterAnswer(ctx)
 		//Waiting music section
 		waitingSong, err := ch.Play("waitingSong ID", "sound:rick-astley")
+		defer func() {
+			// Never leave the caller in silence, or with the waiting music
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			if waitingSong != nil {
+				waitingSong.Stop()
+			}
+			apologize(ctx, ch, err)
+		}()
 
 		//Get the recording bite audio
 		audio, err := downloadRecordingFromARI(ctx, filename)
@@ -139,80 +367,143 @@ func ValidateSend(filename string,
 
 		}
 		reader := bytes.NewReader(audio)
-		//Send to Deepgram STT
-		err = stt.DgSendPreRecorded(ctx, reader, recResBody)
+		//Send to STT
+		start := time.Now()
+		sttCtx, span := tracing.Tracer.Start(ctx, "stt")
+		transcript, err := backends.STT.Transcribe(sttCtx, reader)
+		span.SetAttributes(attribute.Int("transcript.length", len(transcript)))
+		tracing.End(span, err)
+		metrics.ObserveBackend(metrics.STT, start, err)
+		record.STTLatency = cdr.Duration(time.Since(start))
 		if err != nil {
 			return err
 		}
 
-		//Get the transcription result
-		fmt.Printf("Request ID: %s\n", recResBody.RequestID)
-		if recResBody.Results != nil &&
-			len(recResBody.Results.Channels) > 0 &&
-			len(recResBody.Results.Channels[0].Alternatives) > 0 {
-			transcript := recResBody.Results.Channels[0].Alternatives[0].Transcript
-			fmt.Println("Transcription:", transcript)
+		//Get the transcription result, its personal data masked out of the logs and records
+		masked := backends.Redactor.Mask(transcript)
+		logger(ctx).Info("Transcription received", "transcript", masked)
+		record.Transcript = masked
+		setCallVariable(ctx, ch, TranscriptVariable, masked)
 
-			//Gemini Part
-			gemClient, err := ai.GeminiClient(ctx) //Create Gemini client
-			if err != nil {
-				return err
-			}
-			log.Info("Gemini client created")
-			gemChat, err := ai.GeminiChatClient(ctx, gemClient) //Create Gemini chat session
-			if err != nil {
-				return err
-
-			}
-			log.Info("Gemini chat session created")
+		// Passages of our documents, searched without the personal data
+		passages, sources := retrieve(ctx, backends.Knowledge, masked)
+		record.Sources = sources
 
-			// Send the request to Gemini
-
-			geminiPrompt := fmt.Sprintf(
-				"You are a voice assistant in a phone call. Reply using plain spoken text only. Do not use markdown, lists, emojis, symbols, or formatting. Write short, clear sentences that sound natural when read aloud. Respond to the following user request: %s",
-				transcript,
-			)
-
-			reqResult, err := ai.SendGeminiMessage(ctx, gemChat, geminiPrompt) //Send the transcript to Gemini
-			if err != nil {
-				log.Error("Error sending message to Gemini", "error", err)
-				return err
-			}
-			log.Info("Gemini response received", "response", reqResult)
-
-			// ---------------------TTS Part---------------------
-
-			// --- Generate sound file of result ---- //
-			audioFormat := "wav"
-			pth := "/mnt/tts"
-			URIFileName := fmt.Sprintf("%s_tts", filename)
-			filePath := fmt.Sprintf("%s/%s_tts.%s", pth, filename, audioFormat)
-			_, eror := tts.GetDgFileTTS(ctx, reqResult, filePath)
+		//LLM Part
+		ctx = ai.WithTools(ctx, backends.Tools)
+		chat, err := backends.LLM.NewChat(ctx) //Create the chat session
+		if err != nil {
+			return err
+		}
+		logger(ctx).Info("Chat session created", "model", backends.LLM.Name())
 
-			if eror != nil {
-				log.Error("Error in TTS:", "error", eror)
-				return eror
-			}
-			log.Info("File created successfully", "file=", filePath)
+		// Send the request to the LLM
 
-			// --- play sound of the result ---//
+		request, placeholders, tools := transcript, "", ""
+		if ai.ToolsFrom(ctx) != nil {
+			tools = " Use the tools you are given to act for the caller or to look up what you do not know, never make up their results."
+		}
+		if backends.Redactor != nil && backends.Redactor.LLMPlaceholders {
+			// The LLM only sees placeholders, the values stay in the call
+			request = backends.Redactor.Placeholders(transcript, redact.ValuesFrom(ctx))
+			placeholders = " Personal data of the caller is replaced by placeholders like [CARD_1] or [EMAIL_1], write them as they are when you need them."
+		}
+		prompt := fmt.Sprintf(
+			"You are a voice assistant in a phone call. Reply using plain spoken text only. Do not use markdown, lists, emojis, symbols, or formatting. Write short, clear sentences that sound natural when read aloud.%s%s%s Respond to the following user request: %s",
+			passages, tools, placeholders, request,
+		)
 
-			resUri := fmt.Sprintf("recording:%s", URIFileName)
-			log.Info("Print resUri", "resUri", resUri)
-			log.Info("print channel handler ", "channelHandler", ch)
-			//stop waiting song
+		// The sentences are synthesized and played as the LLM writes them
+		answer := newSpeaker(ctx, backends, ch, fmt.Sprintf("%s_tts", filename), func() {
 			if waitingSong != nil {
-				err := waitingSong.Stop()
-				if err != nil {
-					log.Warn("Error stoping waiting music song", "Error", err)
+				if err := waitingSong.Stop(); err != nil {
+					logger(ctx).Warn("Error stoping waiting music song", "err", err)
 				}
 			}
-			_, errResSoundPlay := promptSound(ctx, ch, resUri, []string{"#"}, 1)
-			if errResSoundPlay != nil {
-				log.Error("Error playing the result of the request", "filePath", filePath)
+			session.setState("answering", "")
+			record.AnswerLatency = cdr.Duration(time.Since(validated))
+			metrics.AnswerLatency.Observe(time.Since(validated).Seconds())
+		})
+		defer answer.close()
+		var sentences ai.Sentences
+		say := func(text string) error {
+			for _, sentence := range sentences.Add(text) {
+				// The caller hears their own data back in place of the placeholders
+				if err := answer.say(redact.ValuesFrom(ctx).Restore(sentence)); err != nil {
+					return err
+				}
 			}
-			log.Info("Sound Played successfully", "sound", filePath)
+			return nil
+		}
+
+		start = time.Now()
+		llmCtx, span := tracing.Tracer.Start(ctx, "llm", trace.WithAttributes(
+			attribute.String("llm.model", backends.LLM.Name()),
+			attribute.Int("prompt.length", len(prompt)),
+			attribute.Bool("llm.streaming", streamingEnabled()),
+		))
+		var reqResult string
+		if streamingEnabled() {
+			reqResult, err = ai.Stream(llmCtx, chat, prompt, say) //Send the transcript to the LLM
+		} else if reqResult, err = chat.Send(llmCtx, prompt); err == nil && strings.TrimSpace(reqResult) != "" {
+			// A single clip, once the answer is complete
+			err = answer.say(redact.ValuesFrom(ctx).Restore(reqResult))
+		}
+		if rest := sentences.Flush(); err == nil && rest != "" {
+			err = answer.say(redact.ValuesFrom(ctx).Restore(rest))
+		}
+		span.SetAttributes(attribute.Int("answer.length", len(reqResult)))
+		tracing.End(span, err)
+		metrics.ObserveBackend(metrics.LLM, start, err)
+		record.LLMLatency = cdr.Duration(time.Since(start))
+		if err == nil {
+			reqResult = redact.ValuesFrom(ctx).Restore(reqResult)
+			masked = backends.Redactor.Mask(reqResult)
+			logger(ctx).Info("LLM response received", "response", masked)
+			record.Answer = masked
+			setCallVariable(ctx, ch, AnswerVariable, masked)
+		}
+		// The caller hears the end of what was said, then the apology on error
+		ttsErr := answer.wait()
+		record.TTSLatency = answer.latency()
+		if err != nil {
+			logger(ctx).Error("Error sending message to the LLM", "err", err, "heard", answer.heard())
+			return err
+		}
+		if ttsErr != nil {
+			logger(ctx).Error("Cannot speak the answer", "err", ttsErr)
+			return ttsErr
+		}
+
+		// The whole answer, for the records and to listen to it again
+		URIFileName := fmt.Sprintf("%s_tts", filename)
+		filePath, err := answer.join(URIFileName)
+		if err != nil {
+			logger(ctx).Error("Error writing the answer file", "err", err)
+			return err
+		}
+		record.TTSFile = filePath
+		session.addFile(URIFileName)
+		logger(ctx).Info("File created successfully", "file", filePath)
+
+		// The transfer, hangup or hold asked by the LLM run once the caller heard
+		// the answer, it is not replayed
+		if session.hasAfterAnswer() {
+			return nil
+		}
+		// The answer plays again until the caller presses #, as the menu prompts
+		if answer.waitSkip(play.DefaultFirstDigitTimeout) || ctx.Err() != nil {
+			return nil
+		}
+		answer.close()
+		resUri, err := mediaURI(ctx, URIFileName)
+		if err != nil {
+			return err
+		}
+		if _, err := promptSound(ctx, ch, resUri, []string{"#"}, 1); err != nil {
+			logger(ctx).Error("Error playing the result of the request", "file", filePath, "err", err)
 		}
+		logger(ctx).Info("Sound Played successfully", "file", filePath)
 
 		return nil
 
@@ -0,0 +1,196 @@
+package ivr_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ari/internal/ai"
+	"ari/internal/cdr"
+	"ari/internal/fakeari"
+	"ari/internal/ivr"
+	"ari/internal/media"
+	"ari/internal/stt"
+	"ari/internal/tts"
+
+	"github.com/CyCoreSystems/ari/v5/client/native"
+)
+
+// TestCall records a question, sends it and listens to the answer again
+// before hanging up, against the fake ARI and the fake backends
+func TestCall(t *testing.T) {
+	srv, err := fakeari.New(fakeari.Options{
+		Application:       "ivr-test",
+		Username:          "u",
+		Password:          "p",
+		PlaybackDuration:  20 * time.Millisecond,
+		RecordingDuration: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// The media helper of the Asterisk host, storing the uploaded answers
+	helper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		srv.StoreRecording(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/media/"), ".wav"), data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer helper.Close()
+
+	t.Setenv("TTS_DIR", t.TempDir())
+	t.Setenv("ARI_URL", srv.URL())
+	t.Setenv("ARI_USERNAME", "u")
+	t.Setenv("ARI_PASSWORD", "p")
+	t.Setenv("MEDIA_UPLOAD_URL", helper.URL+"/media")
+
+	client, err := native.Connect(&native.Options{
+		Application:  "ivr-test",
+		URL:          srv.URL(),
+		WebsocketURL: srv.WebsocketURL(),
+		Username:     "u",
+		Password:     "p",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	publisher, err := media.NewARI(client, ivr.TTSDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := &ivr.Backends{
+		STT:   &stt.Fake{Default: "What are your opening hours?"},
+		TTS:   &tts.Fake{WordDuration: 10 * time.Millisecond, MinDuration: 50 * time.Millisecond},
+		LLM:   &ai.Fake{Default: "We are open from nine to five. Come and see us."},
+		Media: publisher,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- ivr.Start(ctx, client, backends, cdr.Discard{}) }()
+	// Give Start time to subscribe to StasisStart
+	time.Sleep(200 * time.Millisecond)
+
+	const id = "chan-1"
+	channel := "/channels/" + id
+	if err := srv.StartCall(fakeari.Call{ID: id, CallerNumber: "1000", Exten: "100"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// waitPlay waits for the IVR to play a media ending with name
+	waitPlay := func(name string) fakeari.Command {
+		t.Helper()
+		cmd, err := srv.WaitCommand(ctx, func(cmd fakeari.Command) bool {
+			media, _ := cmd.Body["media"].(string)
+			return cmd.Method == "POST" && strings.HasPrefix(cmd.Path, channel+"/play/") && strings.HasSuffix(media, name)
+		})
+		if err != nil {
+			t.Fatalf("%s never played: %v", name, err)
+		}
+		return cmd
+	}
+	press := func(digits string) {
+		t.Helper()
+		if err := srv.SendDTMF(id, digits); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitPlay("welcome-ari")
+	srv.QueueRecording(id, question())
+	press("1")
+	waitPlay("after_recording")
+	press("3")
+	// The whole answer plays again once the caller heard its sentences
+	answer := waitPlay("_tts")
+	press("#")
+	if _, err := srv.WaitCommand(ctx, func(cmd fakeari.Command) bool {
+		media, _ := cmd.Body["media"].(string)
+		return cmd.Seq > answer.Seq && strings.Contains(media, "after_recording")
+	}); err != nil {
+		t.Fatal("no menu after the answer:", err)
+	}
+	press("0")
+	if _, err := srv.WaitCommand(ctx, func(cmd fakeari.Command) bool {
+		return cmd.Method == "DELETE" && cmd.Path == channel
+	}); err != nil {
+		t.Fatal("never hung up:", err)
+	}
+	// The call ended, the drain is immediate
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+
+	var answered, recorded, replayed bool
+	for _, cmd := range srv.Commands() {
+		str := func(k string) string {
+			v, _ := cmd.Body[k].(string)
+			return v
+		}
+		switch {
+		case cmd.Method == "POST" && cmd.Path == channel+"/answer":
+			answered = true
+		case cmd.Method == "POST" && cmd.Path == channel+"/record":
+			recorded = strings.HasPrefix(str("name"), "msg_"+id+"_")
+		case cmd.Method == "POST" && strings.HasPrefix(cmd.Path, channel+"/play/"):
+			if strings.HasPrefix(str("media"), "recording:msg_") && strings.HasSuffix(str("media"), "_tts") {
+				replayed = true
+			}
+		}
+	}
+	if !answered {
+		t.Error("the call was not answered")
+	}
+	if !recorded {
+		t.Error("no msg_ recording of the question")
+	}
+	if !replayed {
+		t.Error("the whole answer was not played as recording:msg_..._tts")
+	}
+}
+
+// question returns a second of tone, the audio of the recorded question
+func question() []byte {
+	samples := make([]int16, tts.SampleRate)
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/tts.SampleRate))
+	}
+	var buf bytes.Buffer
+	tts.WriteWAV(&buf, samples, tts.SampleRate)
+	return buf.Bytes()
+}
+
+// TestStartInvalidSettings refuses the invalid admission and drain settings
+// before taking calls
+func TestStartInvalidSettings(t *testing.T) {
+	for _, env := range [][2]string{
+		{"MAX_CALLS", "many"},
+		{"OVERFLOW", "drop"},
+		{"DRAIN_TIMEOUT", "soon"},
+		{"DRAIN_TIMEOUT", "-1s"},
+		{"QUEUE_TIMEOUT", "300"},
+		{"QUEUE_TIMEOUT", "0s"},
+	} {
+		t.Run(env[0]+"="+env[1], func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if err := ivr.Start(context.Background(), nil, &ivr.Backends{}, cdr.Discard{}); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
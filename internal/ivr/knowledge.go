@@ -0,0 +1,44 @@
+package ivr
+
+import (
+	"context"
+
+	"ari/internal/cdr"
+	"ari/internal/knowledge"
+	"ari/internal/metrics"
+	"ari/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// retrieve searches the knowledge base for the question, returning the
+// passages for the prompt and their citations for the record. The turn goes
+// on without them when the search fails.
+func retrieve(ctx context.Context, base *knowledge.Base, question string) (string, []cdr.Source) {
+	if base == nil {
+		return "", nil
+	}
+	ctx, span := tracing.Tracer.Start(ctx, "retrieval")
+	passages, err := base.Search(ctx, question)
+	span.SetAttributes(attribute.Int("passages", len(passages)))
+	tracing.End(span, err)
+	if err != nil {
+		metrics.KnowledgeSearches.WithLabelValues("error").Inc()
+		logger(ctx).Warn("Knowledge search failed, answering without it", "err", err)
+		return "", nil
+	}
+	if len(passages) == 0 {
+		metrics.KnowledgeSearches.WithLabelValues("miss").Inc()
+		logger(ctx).Info("No knowledge passage for the question")
+		return "", nil
+	}
+	metrics.KnowledgeSearches.WithLabelValues("hit").Inc()
+	sources := make([]cdr.Source, len(passages))
+	refs := make([]string, len(passages))
+	for i, p := range passages {
+		sources[i] = cdr.Source{Ref: p.Cite(), Score: p.Score}
+		refs[i] = p.Cite()
+	}
+	logger(ctx).Info("Knowledge passages found", "sources", refs)
+	return knowledge.Prompt(passages), sources
+}
@@ -3,22 +3,24 @@ package ivr
 import (
 	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
 	"time"
 
+	"ari/internal/ariutil"
+	"ari/internal/metrics"
+	"ari/internal/tracing"
+
 	"github.com/CyCoreSystems/ari/v5"
-	"github.com/charmbracelet/log"
-	apiPrerecordedInterfaces "github.com/deepgram/deepgram-go-sdk/pkg/api/prerecorded/v1/interfaces"
-	apiSpeakResponseInterfaces "github.com/deepgram/deepgram-go-sdk/pkg/api/speak/v1/rest/interfaces"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func RecordingRequest(filename string) ChannelHandler {
-	return func(ctx context.Context, ch *ari.ChannelHandle) error {
+	return func(ctx context.Context, ch *ari.ChannelHandle) (err error) {
 		// The default directory for recordings is /var/spool/asterisk/recording/
+		_, span := tracing.Tracer.Start(ctx, "recording", trace.WithAttributes(attribute.String("recording.name", filename)))
+		defer func() { tracing.End(span, err) }()
 
-		rec, err := ch.Record(filename, &ari.RecordingOptions{
+		rec, err := ch.StageRecord(filename, &ari.RecordingOptions{
 			Format:      "wav",
 			MaxDuration: 120 * time.Second,
 			MaxSilence:  5 * time.Second,
@@ -26,30 +28,40 @@ func RecordingRequest(filename string) ChannelHandler {
 			Beep:        true,
 			Terminate:   "#"},
 		)
+		if err != nil {
+			logger(ctx).Error("Failed to stage recording", "err", err)
+			return err
+		}
+		// Subscribe before starting, a short recording could finish before we listen
+		chanRec := rec.Subscribe("RecordingFinished")
+		defer chanRec.Cancel()
+		err = rec.Exec()
 
 		go func() {
 			<-ctx.Done()
 			rec.Stop()
-			log.Info("Context cancelled, recording stopped.", "filename", filename)
+			logger(ctx).Info("Context cancelled, recording stopped.", "recording", filename)
 
 		}()
 
 		if err != nil {
-			log.Errorf("Failed to start recording: %v", err)
+			logger(ctx).Error("Failed to start recording", "err", err)
 			return err
 		}
-		log.Info("Started recording", "filename", filename)
-		chanRec := rec.Subscribe("RecordingFinished")
+		logger(ctx).Info("Started recording", "recording", filename)
+		sessionFrom(ctx).addRecording(filename)
+		sessionFrom(ctx).setState("recording", "")
+		metrics.Recordings.Inc()
 		<-chanRec.Events()
-		log.Info("Recording finished", "filename", filename)
-		log.Info("The program should stop now!")
+		logger(ctx).Info("Recording finished", "recording", filename)
+		logger(ctx).Debug("The program should stop now!")
 		return nil
 	}
 }
 
 func ListentRecording(filename string) ChannelHandler {
 	return func(ctx context.Context, ch *ari.ChannelHandle) error {
-		log.Info("Playing recording", "filename", filename)
+		logger(ctx).Info("Playing recording", "recording", filename)
 		mediaURI := fmt.Sprintf("recording:%s", filename)
 		_, err := promptSound(ctx, ch, mediaURI, []string{"#"}, 1)
 
@@ -57,24 +69,27 @@ func ListentRecording(filename string) ChannelHandler {
 	}
 }
 
-func downloadRecordingFromARI(ctx context.Context, recordingName string) ([]byte, error) {
-	url := fmt.Sprintf("%s/recordings/stored/%s/file", os.Getenv("ARI_URL"), recordingName)
-	log.Info("GET the ressource", "URL", url)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-
-	if err != nil {
-		return nil, err
-	}
-	req.SetBasicAuth(os.Getenv("ARI_USERNAME"), os.Getenv("ARI_PASSWORD"))
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// ListenAnswer plays again the generated answer <filename>.wav
+func ListenAnswer(filename string) ChannelHandler {
+	return func(ctx context.Context, ch *ari.ChannelHandle) error {
+		mediaURI, err := mediaURI(ctx, filename)
+		if err != nil {
+			return err
+		}
+		logger(ctx).Info("Playing the answer", "media", mediaURI)
+		_, err = promptSound(ctx, ch, mediaURI, []string{"#"}, 1)
+		return err
 	}
-	defer resp.Body.Close()
-
-	return io.ReadAll(resp.Body)
+}
 
+func downloadRecordingFromARI(ctx context.Context, recordingName string) (audio []byte, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "download_recording", trace.WithAttributes(attribute.String("recording.name", recordingName)))
+	defer func() {
+		span.SetAttributes(attribute.Int("recording.bytes", len(audio)))
+		tracing.End(span, err)
+	}()
+	logger(ctx).Info("GET the ressource", "recording", recordingName)
+	return ariutil.DownloadRecording(ctx, recordingName)
 }
 
 func firstRecord(filename string) map[string]ChannelHandler {
@@ -86,14 +101,13 @@ func firstRecord(filename string) map[string]ChannelHandler {
 }
 
 func secondRecord(filename string,
-	recResBody *apiPrerecordedInterfaces.PreRecordedResponse,
-	speakResBody *apiSpeakResponseInterfaces.SpeakResponse,
+	backends *Backends,
 	h *ari.ChannelHandle) map[string]ChannelHandler {
 
 	return map[string]ChannelHandler{
 		"1":       RecordingRequest(filename),
 		"2":       ListentRecording(filename),
-		"3":       ValidateSend(filename, recResBody, speakResBody, h),
+		"3":       ValidateSend(filename, backends, h),
 		"0":       StopCall,
 		"default": DoNothing,
 	}
@@ -101,15 +115,14 @@ func secondRecord(filename string,
 }
 
 func thirdRecord(filename string, filenameRes string,
-	recResBody *apiPrerecordedInterfaces.PreRecordedResponse,
-	speakResBody *apiSpeakResponseInterfaces.SpeakResponse,
+	backends *Backends,
 	h *ari.ChannelHandle) map[string]ChannelHandler {
 
 	return map[string]ChannelHandler{
 		"1":       RecordingRequest(filename),
 		"2":       ListentRecording(filename),
-		"3":       ValidateSend(filename, recResBody, speakResBody, h),
-		"4":       ListentRecording(filenameRes),
+		"3":       ValidateSend(filename, backends, h),
+		"4":       ListenAnswer(filenameRes),
 		"0":       StopCall,
 		"default": DoNothing,
 	}
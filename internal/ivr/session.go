@@ -0,0 +1,378 @@
+package ivr
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ari/internal/ai"
+	"ari/internal/cdr"
+	"ari/internal/logging"
+	"ari/internal/redact"
+	"ari/internal/retention"
+
+	"github.com/CyCoreSystems/ari/v5"
+	"github.com/charmbracelet/log"
+)
+
+// callSession is the state of a call shared by its handlers. Its methods do
+// nothing on a nil session, for handlers running outside of callHandl.
+type callSession struct {
+	ID         string // call UUID, unique even when Asterisk reuses channel IDs
+	Channel    string
+	Caller     string
+	CallerName string
+	Exten      string // dialed number
+	Context    string // dialplan context the call came from
+
+	h        *ari.ChannelHandle
+	backends *Backends
+	values   *redact.Values     // personal data swapped for placeholders in the LLM prompts
+	cancel   context.CancelFunc // ends the call
+	turns    atomic.Int32
+
+	mu          sync.Mutex
+	state       string // stage of the IVR, ex : "menu", "recording"
+	menu        string // current menu node
+	transferred bool   // the channel left for the dialplan and must not be hung up
+	interrupted bool   // the call is ended by the IVR, the caller hears goodbye
+	record      cdr.Record
+	recordings  []string                // stored recordings made during the call
+	files       []string                // audio generated for the call in TTSDir
+	after       []func(context.Context) // actions of the LLM tools, run once the answer is played
+}
+
+type sessionKey struct{}
+
+func newCallSession(h *ari.ChannelHandle, data ari.ChannelData, backends *Backends) *callSession {
+	s := &callSession{
+		ID:       newCallID(),
+		Channel:  data.ID,
+		h:        h,
+		backends: backends,
+		values:   redact.NewValues(),
+		state:    "ringing",
+	}
+	if data.Caller != nil {
+		s.Caller = data.Caller.Number
+		s.CallerName = data.Caller.Name
+	}
+	if data.Dialplan != nil {
+		s.Exten = data.Dialplan.Exten
+		s.Context = data.Dialplan.Context
+	}
+	s.record = cdr.Record{
+		CallID:  s.ID,
+		Channel: s.Channel,
+		Caller:  s.Caller,
+		Exten:   s.Exten,
+		Start:   time.Now(),
+	}
+	return s
+}
+
+// nextTurn returns the number of the turn starting, from 1
+func (s *callSession) nextTurn() int {
+	if s == nil {
+		return 0
+	}
+	return int(s.turns.Add(1))
+}
+
+func (s *callSession) answered() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Answer = time.Now()
+}
+
+// setState notes the stage the IVR is at, and the menu node when in a menu
+func (s *callSession) setState(state string, menu string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	if menu != "" {
+		s.menu = menu
+	}
+}
+
+// selectMenu notes a digit pressed in a menu
+func (s *callSession) selectMenu(menu string, digit string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Menus = append(s.record.Menus, cdr.Menu{Menu: menu, Digit: digit, Time: time.Now()})
+}
+
+func (s *callSession) addTurn(t cdr.Turn) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Turns = append(s.record.Turns, t)
+}
+
+func (s *callSession) addError(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Errors = append(s.record.Errors, err.Error())
+}
+
+// addRecording notes a stored recording of the caller
+func (s *callSession) addRecording(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.recordings, name) {
+		s.recordings = append(s.recordings, name)
+	}
+}
+
+// addFile notes an audio file generated for the call, <name>.wav in TTSDir
+func (s *callSession) addFile(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.files, name) {
+		s.files = append(s.files, name)
+	}
+}
+
+// flag marks the call for review, the first reason is kept
+func (s *callSession) flag(reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Review == "" {
+		s.record.Review = reason
+	}
+}
+
+// setConsent notes the consent decision of the caller
+func (s *callSession) setConsent(c *cdr.Consent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Consent = c
+}
+
+// hangup notes why the call ended, the first cause is kept
+func (s *callSession) hangup(cause string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.HangupCause == "" {
+		s.record.HangupCause = cause
+	}
+}
+
+// afterAnswer queues an action to run once the answer being prepared is played
+func (s *callSession) afterAnswer(action func(context.Context)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.after = append(s.after, action)
+}
+
+// hasAfterAnswer reports whether actions wait for the answer to be played
+func (s *callSession) hasAfterAnswer() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.after) > 0
+}
+
+// runAfterAnswer runs the actions queued during the turn, in order
+func (s *callSession) runAfterAnswer(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	actions := s.after
+	s.after = nil
+	s.mu.Unlock()
+	for _, action := range actions {
+		if ctx.Err() != nil {
+			return
+		}
+		action(ctx)
+	}
+}
+
+// transfer sends the channel to an extension of the dialplan, in the context
+// the call came from when dialplanContext is empty
+func (s *callSession) transfer(ctx context.Context, dialplanContext string, exten string, priority int) error {
+	if s == nil {
+		return errNoCall
+	}
+	if dialplanContext == "" {
+		dialplanContext = s.Context
+	}
+	s.setTransferred(true)
+	if err := s.h.Continue(dialplanContext, exten, priority); err != nil {
+		s.setTransferred(false)
+		return err
+	}
+	s.hangup(fmt.Sprintf("transferred to %s@%s", exten, dialplanContext))
+	return nil
+}
+
+// setTransferred notes whether the channel is continuing in the dialplan
+func (s *callSession) setTransferred(transferred bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transferred = transferred
+}
+
+func (s *callSession) isTransferred() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transferred
+}
+
+// interrupt ends the call from the outside, ex : at the drain deadline
+func (s *callSession) interrupt(cause string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.interrupted = true
+	if s.record.HangupCause == "" {
+		s.record.HangupCause = cause
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+func (s *callSession) isInterrupted() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interrupted
+}
+
+// info returns what the admin API shows of the call
+func (s *callSession) info() CallInfo {
+	if s == nil {
+		return CallInfo{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return CallInfo{
+		CallID:  s.ID,
+		Channel: s.Channel,
+		Caller:  s.Caller,
+		Exten:   s.Exten,
+		State:   s.state,
+		Menu:    s.menu,
+		Turns:   int(s.turns.Load()),
+		Start:   s.record.Start,
+	}
+}
+
+// finish ends the record and returns a copy of it, flagging the calls which
+// went wrong for review
+func (s *callSession) finish() *cdr.Record {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.End = time.Now()
+	if s.record.Review == "" && s.failed() {
+		s.record.Review = "errors"
+	}
+	r := s.record
+	return &r
+}
+
+// failed reports whether the call had errors, with the lock held
+func (s *callSession) failed() bool {
+	if len(s.record.Errors) > 0 {
+		return true
+	}
+	for _, t := range s.record.Turns {
+		if t.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// audio returns the audio of the call for the retention policy
+func (s *callSession) audio(record *cdr.Record) retention.Call {
+	if s == nil {
+		return retention.Call{Record: record}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return retention.Call{
+		Record:     record,
+		Recordings: slices.Clone(s.recordings),
+		Files:      slices.Clone(s.files),
+	}
+}
+
+func withSession(ctx context.Context, s *callSession) context.Context {
+	ctx = context.WithValue(ctx, sessionKey{}, s)
+	ctx = redact.WithValues(ctx, s.values)
+	ctx = ai.WithCall(ctx, ai.Call{ID: s.ID, Caller: s.Caller, Exten: s.Exten})
+	return logging.With(ctx, "call_id", s.ID, "channel", s.Channel, "caller_id", s.Caller)
+}
+
+// sessionFrom returns the session of the call, or nil outside of a call
+func sessionFrom(ctx context.Context) *callSession {
+	s, _ := ctx.Value(sessionKey{}).(*callSession)
+	return s
+}
+
+// logger returns the ivr logger carrying the fields of the call in ctx
+func logger(ctx context.Context) *log.Logger {
+	return logging.For(ctx, "ivr")
+}
+
+// newCallID returns a random UUID (version 4)
+func newCallID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
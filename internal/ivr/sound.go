@@ -2,10 +2,13 @@ package ivr
 
 import (
 	"context"
+	"os"
+
+	"ari/internal/media"
+	"ari/internal/prompts"
 
 	"github.com/CyCoreSystems/ari/v5"
 	"github.com/CyCoreSystems/ari/v5/ext/play"
-	"github.com/charmbracelet/log"
 )
 
 func PlaySound(ctx context.Context, ch *ari.ChannelHandle, soundURI string) error {
@@ -16,18 +19,55 @@ func PlaySound(ctx context.Context, ch *ari.ChannelHandle, soundURI string) erro
 	}()
 
 	if err := play.Play(ctx, ch, play.URI(soundURI)).Err(); err != nil {
-		log.Errorf("Failed to play %s error= %v", soundURI, err)
+		logger(ctx).Error("Failed to play", "sound", soundURI, "err", err)
 		return err
 	}
-	log.Infof("Played %s", soundURI)
+	logger(ctx).Info("Played", "sound", soundURI)
 	return nil
 }
 
+// promptURI returns the media of a menu prompt for the call, rendered from its
+// template or pre-recorded
+func promptURI(ctx context.Context, name string) string {
+	session := sessionFrom(ctx)
+	if session == nil {
+		return "sound:" + name
+	}
+	vars := prompts.NewVars(session.ID, session.Caller, session.CallerName, session.Exten)
+	uri, callFile, err := session.backends.Prompts.URI(ctx, name, vars)
+	if callFile != "" {
+		// Archived or deleted with the audio of the call
+		session.addFile(callFile)
+	}
+	if err != nil && ctx.Err() == nil {
+		logger(ctx).Warn("Cannot render the prompt, playing the pre-recorded sound", "prompt", name, "err", err)
+	}
+	return uri
+}
+
+// mediaURI returns the media URI of the generated audio <name>.wav of TTSDir
+func mediaURI(ctx context.Context, name string) (string, error) {
+	if session := sessionFrom(ctx); session != nil && session.backends.Media != nil {
+		return session.backends.Media.URI(ctx, name)
+	}
+	return media.Shared{}.URI(ctx, name)
+}
+
+// apologize tells the caller the question could not be answered (APOLOGY_SOUND)
+func apologize(ctx context.Context, ch *ari.ChannelHandle, cause error) {
+	sound := os.Getenv("APOLOGY_SOUND")
+	if sound == "" {
+		sound = "sound:an-error-has-occured"
+	}
+	logger(ctx).Warn("Apologizing to the caller", "err", cause)
+	PlaySound(ctx, ch, sound)
+}
+
 func promptSound(ctx context.Context, ch *ari.ChannelHandle, soundURI string, listDigtOpt []string, numReplay int) (*play.Result, error) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Info("PromptSound context cancelled")
+			logger(ctx).Info("PromptSound context cancelled")
 			return nil, ctx.Err()
 		default:
 			res, er := play.Prompt(ctx, ch,
@@ -35,12 +75,12 @@ func promptSound(ctx context.Context, ch *ari.ChannelHandle, soundURI string, li
 				play.MatchDiscrete(listDigtOpt),
 				play.Replays(numReplay)).Result()
 			if er != nil {
-				log.Info("Error detected", "error", er)
+				logger(ctx).Info("Error detected", "err", er)
 				return nil, er
 
 			}
 			if res.DTMF != "" {
-				log.Info("resultat from the prompt is ", "value", res.DTMF)
+				logger(ctx).Debug("resultat from the prompt is ", "digit", res.DTMF)
 				return res, nil
 
 			}
@@ -0,0 +1,217 @@
+package knowledge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"ari/internal/ai"
+
+	"google.golang.org/genai"
+)
+
+// Embedder turns texts into vectors, close for texts of close meaning
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Name identifies the provider and model, an index is searched with the
+	// embedder it was built with
+	Name() string
+}
+
+// NewEmbedder returns the embedder of KNOWLEDGE_EMBEDDINGS: "openai" (an
+// OpenAI-compatible embeddings API, a local Ollama by default), "gemini" or
+// "hash" (local word hashing, no model)
+func NewEmbedder() (Embedder, error) {
+	switch name := os.Getenv("KNOWLEDGE_EMBEDDINGS"); name {
+	case "", "openai":
+		return NewOpenAIEmbedder(), nil
+	case "gemini":
+		model := os.Getenv("EMBEDDINGS_MODEL")
+		if model == "" {
+			model = "gemini-embedding-001"
+		}
+		return GeminiEmbedder{Model: model}, nil
+	case "hash":
+		return Hash{Dims: 1024}, nil
+	default:
+		return nil, fmt.Errorf("unknown KNOWLEDGE_EMBEDDINGS %q, expected openai, gemini or hash", name)
+	}
+}
+
+// OpenAIEmbedder gets the embeddings from an OpenAI-compatible API, like a
+// local Ollama, vLLM or llama.cpp server
+type OpenAIEmbedder struct {
+	BaseURL string // ex : http://localhost:11434/v1
+	APIKey  string // optional for local servers
+	Model   string
+}
+
+// NewOpenAIEmbedder returns the model of EMBEDDINGS_MODEL (nomic-embed-text
+// by default) served at EMBEDDINGS_BASE_URL, OPENAI_BASE_URL by default, with
+// EMBEDDINGS_API_KEY or OPENAI_API_KEY
+func NewOpenAIEmbedder() OpenAIEmbedder {
+	e := OpenAIEmbedder{
+		BaseURL: envOr("EMBEDDINGS_BASE_URL", envOr("OPENAI_BASE_URL", "http://localhost:11434/v1")),
+		APIKey:  envOr("EMBEDDINGS_API_KEY", os.Getenv("OPENAI_API_KEY")),
+		Model:   envOr("EMBEDDINGS_MODEL", "nomic-embed-text"),
+	}
+	e.BaseURL = strings.TrimSuffix(e.BaseURL, "/")
+	return e
+}
+
+func (e OpenAIEmbedder) Name() string {
+	return "openai:" + e.Model
+}
+
+func (e OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]any{"model": e.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.BaseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("embeddings API returned %s", resp.Status)
+	}
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("embeddings API: invalid response: %w", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings API returned %d embeddings for %d texts", len(result.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, errors.New("embeddings API: embedding index out of range")
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// GeminiEmbedder gets the embeddings from Gemini, with GEMINI_API_KEY
+type GeminiEmbedder struct {
+	Model string // ex : gemini-embedding-001
+}
+
+func (e GeminiEmbedder) Name() string {
+	return "gemini:" + e.Model
+}
+
+func (e GeminiEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	client, err := ai.GeminiClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]*genai.Content, len(texts))
+	for i, text := range texts {
+		contents[i] = genai.NewContentFromText(text, genai.RoleUser)
+	}
+	resp, err := client.Models.EmbedContent(ctx, e.Model, contents, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("gemini returned %d embeddings for %d texts", len(resp.Embeddings), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for i, emb := range resp.Embeddings {
+		vectors[i] = emb.Values
+	}
+	return vectors, nil
+}
+
+// Hash embeds the words and word pairs of the texts by feature hashing. It
+// needs no model and matches on shared words only, not on meaning: for small
+// knowledge bases, offline setups and the tests.
+type Hash struct {
+	Dims int
+}
+
+func (h Hash) Name() string {
+	return fmt.Sprintf("hash:%d", h.Dims)
+}
+
+func (h Hash) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, h.Dims)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		add := func(feature string) {
+			f := fnv.New64a()
+			f.Write([]byte(feature))
+			sum := f.Sum64()
+			sign := float32(1)
+			if sum>>63 == 1 {
+				sign = -1
+			}
+			v[sum%uint64(h.Dims)] += sign
+		}
+		words = slices.DeleteFunc(words, func(w string) bool { return len(w) < 3 || stopWords[w] })
+		for j, w := range words {
+			add(stem(w))
+			if j > 0 {
+				add(stem(words[j-1]) + " " + stem(w))
+			}
+		}
+		// Damp the repeated words
+		for j, x := range v {
+			if x != 0 {
+				v[j] = float32(math.Copysign(math.Log1p(math.Abs(float64(x))), float64(x)))
+			}
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+// stopWords carry no meaning of their own, they are not embedded by Hash
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "you": true, "your": true, "our": true,
+	"are": true, "was": true, "can": true, "does": true, "from": true, "this": true, "that": true,
+	"have": true, "has": true, "will": true, "what": true, "when": true, "where": true, "which": true,
+	"who": true, "how": true, "much": true, "many": true, "about": true, "there": true, "please": true,
+}
+
+// stem drops the plural of a word, "hours" matches "hour"
+func stem(w string) string {
+	if len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") {
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
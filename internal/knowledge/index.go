@@ -0,0 +1,136 @@
+package knowledge
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// Chunk is a passage of a document, with its embedding
+type Chunk struct {
+	Source  string // path of the document, as ingested
+	Section string // heading of the passage, "" at the top of the document
+	Page    int    // page of a PDF, from 1, 0 for the other documents
+	Text    string
+	Vector  []float32 // normalized
+}
+
+// Index is the vector store of the knowledge base, searched by brute force:
+// a few thousand passages are compared in a millisecond
+type Index struct {
+	Embedder string            // name of the embedder of the vectors
+	Sources  map[string]string // document path -> hash of its content
+	Chunks   []Chunk
+}
+
+// ErrNoIndex is returned by LoadIndex when the file does not exist
+var ErrNoIndex = errors.New("knowledge index not found")
+
+// NewIndex returns an empty index of the vectors of embedder
+func NewIndex(embedder string) *Index {
+	return &Index{Embedder: embedder, Sources: map[string]string{}}
+}
+
+// LoadIndex reads the index file written by Save
+func LoadIndex(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNoIndex, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var idx Index
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("invalid knowledge index %s: %w", path, err)
+	}
+	if idx.Sources == nil {
+		idx.Sources = map[string]string{}
+	}
+	return &idx, nil
+}
+
+// Save writes the index to path, replacing it at once
+func (idx *Index) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".knowledge-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Replace sets the passages of a document, removing its previous ones
+func (idx *Index) Replace(source string, hash string, chunks []Chunk) {
+	idx.Remove(source)
+	for i := range chunks {
+		normalize(chunks[i].Vector)
+	}
+	idx.Chunks = append(idx.Chunks, chunks...)
+	idx.Sources[source] = hash
+}
+
+// Remove drops the passages of a document
+func (idx *Index) Remove(source string) {
+	idx.Chunks = slices.DeleteFunc(idx.Chunks, func(c Chunk) bool { return c.Source == source })
+	delete(idx.Sources, source)
+}
+
+// Passage is a chunk found for a query, with its cosine similarity
+type Passage struct {
+	Chunk
+	Score float64
+}
+
+// Search returns the k chunks closest to the vector, best first, scoring at
+// least minScore
+func (idx *Index) Search(vector []float32, k int, minScore float64) []Passage {
+	vector = slices.Clone(vector)
+	normalize(vector)
+	var passages []Passage
+	for _, c := range idx.Chunks {
+		if len(c.Vector) != len(vector) {
+			continue
+		}
+		var dot float64
+		for i, x := range c.Vector {
+			dot += float64(x) * float64(vector[i])
+		}
+		if dot >= minScore {
+			passages = append(passages, Passage{Chunk: c, Score: dot})
+		}
+	}
+	sort.SliceStable(passages, func(i, j int) bool { return passages[i].Score > passages[j].Score })
+	if len(passages) > k {
+		passages = passages[:k]
+	}
+	return passages
+}
+
+// normalize scales v to a length of 1, cosine similarities are dot products then
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+}
@@ -0,0 +1,91 @@
+package knowledge
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	v := []float32{3, 4}
+	normalize(v)
+	if math.Abs(float64(v[0])-0.6) > 1e-6 || math.Abs(float64(v[1])-0.8) > 1e-6 {
+		t.Errorf("got %v, want [0.6 0.8]", v)
+	}
+	zero := []float32{0, 0}
+	normalize(zero)
+	if zero[0] != 0 || zero[1] != 0 {
+		t.Errorf("zero vector: got %v", zero)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	idx := NewIndex("fake")
+	idx.Replace("a.md", "1", []Chunk{
+		{Source: "a.md", Text: "exact", Vector: []float32{2, 0, 0}},
+		{Source: "a.md", Text: "close", Vector: []float32{1, 1, 0}},
+		{Source: "a.md", Text: "far", Vector: []float32{1, 0, 3}},
+		{Source: "a.md", Text: "orthogonal", Vector: []float32{0, 0, 1}},
+		{Source: "a.md", Text: "other embedder", Vector: []float32{1, 0}},
+	})
+	query := []float32{5, 0, 0}
+
+	texts := func(passages []Passage) []string {
+		var texts []string
+		for _, p := range passages {
+			texts = append(texts, p.Text)
+		}
+		return texts
+	}
+	passages := idx.Search(query, 10, 0.3)
+	if got, want := texts(passages), []string{"exact", "close", "far"}; !slices.Equal(got, want) {
+		t.Errorf("ranking: got %v, want %v", got, want)
+	}
+	if math.Abs(passages[0].Score-1) > 1e-6 || math.Abs(passages[1].Score-math.Sqrt2/2) > 1e-6 {
+		t.Errorf("scores: got %v and %v", passages[0].Score, passages[1].Score)
+	}
+	if got, want := texts(idx.Search(query, 10, 0.5)), []string{"exact", "close"}; !slices.Equal(got, want) {
+		t.Errorf("min score: got %v, want %v", got, want)
+	}
+	if got, want := texts(idx.Search(query, 1, 0)), []string{"exact"}; !slices.Equal(got, want) {
+		t.Errorf("top 1: got %v, want %v", got, want)
+	}
+	if query[0] != 5 {
+		t.Errorf("the query was normalized: %v", query)
+	}
+}
+
+func TestIndexReplaceRemove(t *testing.T) {
+	idx := NewIndex("fake")
+	idx.Replace("a.md", "1", []Chunk{{Source: "a.md", Text: "one", Vector: []float32{1}}})
+	idx.Replace("b.md", "1", []Chunk{{Source: "b.md", Text: "two", Vector: []float32{1}}})
+	idx.Replace("a.md", "2", []Chunk{{Source: "a.md", Text: "three", Vector: []float32{1}}})
+	if len(idx.Chunks) != 2 || idx.Sources["a.md"] != "2" {
+		t.Errorf("after the replace: got %+v", idx)
+	}
+	idx.Remove("b.md")
+	if len(idx.Chunks) != 1 || idx.Chunks[0].Text != "three" || len(idx.Sources) != 1 {
+		t.Errorf("after the remove: got %+v", idx)
+	}
+}
+
+func TestSaveLoadIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "knowledge.idx")
+	if _, err := LoadIndex(path); !errors.Is(err, ErrNoIndex) {
+		t.Errorf("missing index: got %v", err)
+	}
+	idx := NewIndex("fake")
+	idx.Replace("a.md", "1", []Chunk{{Source: "a.md", Section: "Intro", Text: "one", Vector: []float32{1, 0}}})
+	if err := idx.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Embedder != "fake" || loaded.Sources["a.md"] != "1" || len(loaded.Chunks) != 1 || loaded.Chunks[0].Section != "Intro" {
+		t.Errorf("got %+v", loaded)
+	}
+}
@@ -0,0 +1,219 @@
+package knowledge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// maxChunk is the size of the passages, in characters
+const maxChunk = 1000
+
+// embedBatch is the number of passages embedded per request
+const embedBatch = 32
+
+// IngestStats tells what an ingestion changed
+type IngestStats struct {
+	Indexed   int // documents added or updated
+	Unchanged int
+	Removed   int // documents gone from the ingested directories
+	Chunks    int // passages embedded
+}
+
+// Ingest adds the Markdown, text and PDF documents of paths (files, or
+// directories walked recursively) to idx. The unchanged documents are
+// skipped, and the documents of the directories which were deleted are
+// removed.
+func Ingest(ctx context.Context, idx *Index, emb Embedder, paths []string) (IngestStats, error) {
+	var stats IngestStats
+	if idx.Embedder != emb.Name() {
+		return stats, fmt.Errorf("the index is built with %s, not %s: rebuild it from scratch", idx.Embedder, emb.Name())
+	}
+	seen := map[string]bool{}
+	for _, root := range paths {
+		root = filepath.Clean(root)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !supported(path) {
+				return nil
+			}
+			seen[path] = true
+			changed, chunks, err := ingestFile(ctx, idx, emb, path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if changed {
+				stats.Indexed++
+				stats.Chunks += chunks
+			} else {
+				stats.Unchanged++
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			for source := range idx.Sources {
+				if !seen[source] && strings.HasPrefix(source, root+string(filepath.Separator)) {
+					idx.Remove(source)
+					stats.Removed++
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+func supported(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".txt", ".pdf":
+		return true
+	}
+	return false
+}
+
+// ingestFile indexes a document again when its content changed
+func ingestFile(ctx context.Context, idx *Index, emb Embedder, path string) (bool, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, 0, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if idx.Sources[path] == hash {
+		return false, 0, nil
+	}
+
+	sections, err := readDocument(path, data)
+	if err != nil {
+		return false, 0, err
+	}
+	chunks := chunkSections(path, sections)
+	for start := 0; start < len(chunks); start += embedBatch {
+		batch := chunks[start:min(start+embedBatch, len(chunks))]
+		texts := make([]string, len(batch))
+		for i, c := range batch {
+			// The heading gives its context to the passage
+			texts[i] = strings.TrimSpace(c.Section + "\n" + c.Text)
+		}
+		vectors, err := emb.Embed(ctx, texts)
+		if err != nil {
+			return false, 0, err
+		}
+		for i := range batch {
+			batch[i].Vector = vectors[i]
+		}
+	}
+	idx.Replace(path, hash, chunks)
+	return true, len(chunks), nil
+}
+
+// section is a part of a document under one heading, or a page of a PDF
+type section struct {
+	heading string
+	page    int
+	text    string
+}
+
+var headingRe = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+
+// readDocument splits a document in sections
+func readDocument(path string, data []byte) ([]section, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pdf":
+		return readPDF(path)
+	case ".md", ".markdown":
+		var sections []section
+		current := section{}
+		var text strings.Builder
+		for _, line := range strings.Split(string(data), "\n") {
+			if m := headingRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+				current.text = text.String()
+				sections = append(sections, current)
+				current, text = section{heading: m[1]}, strings.Builder{}
+				continue
+			}
+			text.WriteString(line)
+			text.WriteByte('\n')
+		}
+		current.text = text.String()
+		return append(sections, current), nil
+	default:
+		return []section{{text: string(data)}}, nil
+	}
+}
+
+func readPDF(path string) ([]section, error) {
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sections []section
+	for i := 1; i <= r.NumPage(); i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i, err)
+		}
+		sections = append(sections, section{page: i, text: text})
+	}
+	return sections, nil
+}
+
+var (
+	blankLinesRe = regexp.MustCompile(`\n\s*\n`)
+	sentenceRe   = regexp.MustCompile(`[^.!?]+[.!?]*\s*`)
+)
+
+// chunkSections cuts the sections in passages of at most maxChunk
+// characters, on paragraph boundaries, or sentence boundaries for the long
+// paragraphs
+func chunkSections(source string, sections []section) []Chunk {
+	var chunks []Chunk
+	for _, s := range sections {
+		var current strings.Builder
+		flush := func() {
+			if text := strings.TrimSpace(current.String()); text != "" {
+				chunks = append(chunks, Chunk{Source: source, Section: s.heading, Page: s.page, Text: text})
+			}
+			current.Reset()
+		}
+		add := func(piece string) {
+			if current.Len() > 0 && current.Len()+len(piece) > maxChunk {
+				flush()
+			}
+			current.WriteString(piece)
+		}
+		for _, paragraph := range blankLinesRe.Split(s.text, -1) {
+			paragraph = strings.Join(strings.Fields(paragraph), " ")
+			if paragraph == "" {
+				continue
+			}
+			if len(paragraph) <= maxChunk {
+				add(paragraph + "\n\n")
+				continue
+			}
+			for _, sentence := range sentenceRe.FindAllString(paragraph, -1) {
+				add(sentence)
+			}
+			add("\n\n")
+		}
+		flush()
+	}
+	return chunks
+}
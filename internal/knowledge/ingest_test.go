@@ -0,0 +1,85 @@
+package knowledge
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	write(t, filepath.Join(docs, "hours.md"), "# Hours\n\nWe are open from nine to five.\n\n## Delivery\n\nDelivery takes two days.\n")
+	write(t, filepath.Join(docs, "sub", "refund.txt"), "A refund takes a week.")
+	write(t, filepath.Join(docs, "logo.png"), "not a document")
+	ctx := context.Background()
+	emb := &fakeEmbedder{}
+	idx := NewIndex(emb.Name())
+
+	stats, err := Ingest(ctx, idx, emb, []string{docs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (IngestStats{Indexed: 2, Chunks: 3}) || len(idx.Chunks) != 3 {
+		t.Errorf("first ingestion: got %+v, %d chunks", stats, len(idx.Chunks))
+	}
+	sections := map[string]bool{}
+	for _, c := range idx.Chunks {
+		sections[c.Section] = true
+	}
+	if !sections["Hours"] || !sections["Delivery"] || !sections[""] {
+		t.Errorf("sections: got %v", sections)
+	}
+
+	// The unchanged documents are not embedded again
+	embedded := emb.texts
+	if stats, err = Ingest(ctx, idx, emb, []string{docs}); err != nil {
+		t.Fatal(err)
+	}
+	if stats != (IngestStats{Unchanged: 2}) || emb.texts != embedded {
+		t.Errorf("second ingestion: got %+v, %d texts embedded", stats, emb.texts-embedded)
+	}
+
+	// A changed document is indexed again, a deleted one removed
+	write(t, filepath.Join(docs, "hours.md"), "# Hours\n\nWe are open from eight to six.\n")
+	if err := os.Remove(filepath.Join(docs, "sub", "refund.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = Ingest(ctx, idx, emb, []string{docs}); err != nil {
+		t.Fatal(err)
+	}
+	if stats != (IngestStats{Indexed: 1, Removed: 1, Chunks: 1}) {
+		t.Errorf("third ingestion: got %+v", stats)
+	}
+	if len(idx.Chunks) != 1 || idx.Chunks[0].Text != "We are open from eight to six." || len(idx.Sources) != 1 {
+		t.Errorf("after the third ingestion: got %+v", idx)
+	}
+
+	// Ingesting a file leaves the other documents of its directory
+	other := filepath.Join(dir, "other.md")
+	write(t, other, "Plan prices.")
+	if stats, err = Ingest(ctx, idx, emb, []string{other}); err != nil {
+		t.Fatal(err)
+	}
+	if stats != (IngestStats{Indexed: 1, Chunks: 1}) || len(idx.Sources) != 2 {
+		t.Errorf("file ingestion: got %+v, %d documents", stats, len(idx.Sources))
+	}
+}
+
+func TestIngestOtherEmbedder(t *testing.T) {
+	idx := NewIndex("fake")
+	if _, err := Ingest(context.Background(), idx, &fakeEmbedder{name: "other"}, []string{t.TempDir()}); err == nil {
+		t.Error("no error")
+	}
+}
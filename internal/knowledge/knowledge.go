@@ -0,0 +1,107 @@
+// Package knowledge is the knowledge base of the bot: Markdown, text and PDF
+// documents cut in passages, embedded into a local vector index. The passages
+// closest to the question of the caller are given to the LLM with the
+// prompt, so it answers about our products from our documents.
+package knowledge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Base searches the index with the embedder it was built with
+type Base struct {
+	Index    *Index
+	Embedder Embedder
+	TopK     int     // passages given to the LLM
+	MinScore float64 // cosine similarity under which a passage is not relevant
+	Timeout  time.Duration
+}
+
+// FromEnv loads the index of KNOWLEDGE_INDEX, built by cmd/knowledge, nil when
+// it is not set. KNOWLEDGE_TOP_K (3), KNOWLEDGE_MIN_SCORE (0.3) and
+// KNOWLEDGE_TIMEOUT (3s) tune the search.
+func FromEnv() (*Base, error) {
+	path := os.Getenv("KNOWLEDGE_INDEX")
+	if path == "" {
+		return nil, nil
+	}
+	emb, err := NewEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := LoadIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Embedder != emb.Name() {
+		return nil, fmt.Errorf("the knowledge index %s is built with %s, not %s", path, idx.Embedder, emb.Name())
+	}
+	b := &Base{Index: idx, Embedder: emb, TopK: 3, MinScore: 0.3, Timeout: 3 * time.Second}
+	if v := os.Getenv("KNOWLEDGE_TOP_K"); v != "" {
+		if b.TopK, err = strconv.Atoi(v); err != nil || b.TopK < 1 {
+			return nil, fmt.Errorf("invalid KNOWLEDGE_TOP_K %q", v)
+		}
+	}
+	if v := os.Getenv("KNOWLEDGE_MIN_SCORE"); v != "" {
+		if b.MinScore, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("invalid KNOWLEDGE_MIN_SCORE %q", v)
+		}
+	}
+	if v := os.Getenv("KNOWLEDGE_TIMEOUT"); v != "" {
+		if b.Timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid KNOWLEDGE_TIMEOUT %q", v)
+		}
+	}
+	return b, nil
+}
+
+// Search returns the passages relevant to the query, best first
+func (b *Base) Search(ctx context.Context, query string) ([]Passage, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.Timeout)
+	defer cancel()
+	vectors, err := b.Embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, errors.New("no embedding for the query")
+	}
+	return b.Index.Search(vectors[0], b.TopK, b.MinScore), nil
+}
+
+// Cite returns the reference of the passage, ex : "docs/plans.md#Pricing"
+// or "docs/guide.pdf#page=4"
+func (p Passage) Cite() string {
+	switch {
+	case p.Page > 0:
+		return fmt.Sprintf("%s#page=%d", p.Source, p.Page)
+	case p.Section != "":
+		return p.Source + "#" + p.Section
+	}
+	return p.Source
+}
+
+// Prompt returns the instructions giving the passages to the LLM, "" without
+// passages
+func Prompt(passages []Passage) string {
+	if len(passages) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(" Answer from the following passages of our documentation when they cover the request. " +
+		"When they do not, say you do not know rather than guessing about our products, and do not read the passage numbers aloud.")
+	for i, p := range passages {
+		fmt.Fprintf(&b, "\n[%d] (%s) %s", i+1, p.Cite(), p.Text)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package knowledge
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vocabulary is the dimensions of the vectors of fakeEmbedder
+var vocabulary = []string{"open", "hours", "price", "plan", "refund", "delivery"}
+
+// fakeEmbedder embeds a text as the counts of the words of vocabulary it holds
+type fakeEmbedder struct {
+	name  string
+	texts int // texts embedded
+}
+
+func (f *fakeEmbedder) Name() string {
+	if f.name == "" {
+		return "fake"
+	}
+	return f.name
+}
+
+func (f *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(vocabulary))
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			for j, v := range vocabulary {
+				if strings.Trim(word, ".,?!:") == v {
+					vectors[i][j]++
+				}
+			}
+		}
+	}
+	f.texts += len(texts)
+	return vectors, nil
+}
+
+func TestBaseSearch(t *testing.T) {
+	emb := &fakeEmbedder{}
+	idx := NewIndex(emb.Name())
+	chunks := []Chunk{
+		{Source: "docs/hours.md", Text: "We are open from nine to five", Vector: []float32{1, 1, 0, 0, 0, 0}},
+		{Source: "docs/plans.md", Section: "Pricing", Text: "The plan costs ten euros", Vector: []float32{0, 0, 1, 1, 0, 0}},
+	}
+	idx.Replace("docs/all.md", "hash", chunks)
+	b := &Base{Index: idx, Embedder: emb, TopK: 3, MinScore: 0.3, Timeout: time.Second}
+
+	passages, err := b.Search(context.Background(), "What is the price of the plan?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passages) != 1 || passages[0].Section != "Pricing" {
+		t.Errorf("got %+v", passages)
+	}
+	if passages, err := b.Search(context.Background(), "  "); err != nil || passages != nil || emb.texts != 1 {
+		t.Errorf("blank query: got %+v, %v, %d texts embedded", passages, err, emb.texts)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	if got := Prompt(nil); got != "" {
+		t.Errorf("without passages: got %q", got)
+	}
+	got := Prompt([]Passage{
+		{Chunk: Chunk{Source: "docs/plans.md", Section: "Pricing", Text: "The plan costs ten euros."}},
+		{Chunk: Chunk{Source: "docs/guide.pdf", Section: "Ignored", Page: 4, Text: "Press reset."}},
+		{Chunk: Chunk{Source: "notes.txt", Text: "Closed on Sundays."}},
+	})
+	for _, want := range []string{
+		"\n[1] (docs/plans.md#Pricing) The plan costs ten euros.",
+		"\n[2] (docs/guide.pdf#page=4) Press reset.",
+		"\n[3] (notes.txt) Closed on Sundays.\n",
+		"say you do not know",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in %q", want, got)
+		}
+	}
+	if !strings.HasPrefix(got, " ") || !strings.HasSuffix(got, "\n") {
+		t.Errorf("not appendable to the instructions: %q", got)
+	}
+}
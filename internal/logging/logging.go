@@ -0,0 +1,87 @@
+// Package logging configures the charmbracelet logger and carries the fields of
+// a call (channel, caller, call ID, menu node, turn) through the context, so
+// every line of a call can be grepped out of a busy node.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+type fieldsKey struct{}
+
+var (
+	mu     sync.RWMutex
+	levels = map[string]log.Level{} // package -> level
+)
+
+// Setup configures the default logger from the environment:
+//
+//	LOG_FORMAT=text|json|logfmt (text by default)
+//	LOG_LEVEL=debug|info|warn|error (info by default)
+//	LOG_LEVELS=ivr=debug,stt=warn (overrides LOG_LEVEL per package)
+func Setup() error {
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "text":
+		log.SetFormatter(log.TextFormatter)
+	case "json":
+		log.SetFormatter(log.JSONFormatter)
+	case "logfmt":
+		log.SetFormatter(log.LogfmtFormatter)
+	default:
+		return fmt.Errorf("unknown LOG_FORMAT %q", format)
+	}
+
+	if lvl := os.Getenv("LOG_LEVEL"); lvl != "" {
+		level, err := log.ParseLevel(lvl)
+		if err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+		log.SetLevel(level)
+	}
+
+	pkgLevels := map[string]log.Level{}
+	if spec := os.Getenv("LOG_LEVELS"); spec != "" {
+		for _, item := range strings.Split(spec, ",") {
+			pkg, lvl, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				return fmt.Errorf("invalid LOG_LEVELS entry %q, expected pkg=level", item)
+			}
+			level, err := log.ParseLevel(lvl)
+			if err != nil {
+				return fmt.Errorf("invalid LOG_LEVELS entry %q: %w", item, err)
+			}
+			pkgLevels[pkg] = level
+		}
+	}
+	mu.Lock()
+	levels = pkgLevels
+	mu.Unlock()
+	return nil
+}
+
+// With returns a context whose logger carries the additional key/value fields
+func With(ctx context.Context, keyvals ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	fields = append(append([]any(nil), fields...), keyvals...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// For returns the logger of package pkg, with the call fields carried by ctx
+func For(ctx context.Context, pkg string) *log.Logger {
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	logger := log.Default().With("pkg", pkg).With(fields...)
+
+	mu.RLock()
+	level, ok := levels[pkg]
+	mu.RUnlock()
+	if ok {
+		logger.SetLevel(level)
+	}
+	return logger
+}
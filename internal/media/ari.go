@@ -0,0 +1,116 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ari/internal/logging"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+// ARI uploads the files to cmd/mediahelper on the Asterisk host, which writes
+// them to the recordings directory, then checks the ARI sees them as stored
+// recordings. Neither a shared volume nor a common uid is needed.
+type ARI struct {
+	UploadURL string // ex : http://asterisk:8089/media
+	Token     string
+	Dir       string
+
+	client ari.Client
+
+	mu       sync.Mutex
+	uploaded map[string]uploadedFile // name -> version uploaded
+}
+
+type uploadedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewARI uploads the files of dir to MEDIA_UPLOAD_URL with MEDIA_UPLOAD_TOKEN
+func NewARI(client ari.Client, dir string) (*ARI, error) {
+	url := os.Getenv("MEDIA_UPLOAD_URL")
+	if url == "" {
+		return nil, fmt.Errorf("MEDIA_UPLOAD_URL is required with MEDIA_TRANSPORT=ari, ex : http://asterisk:8089/media")
+	}
+	return &ARI{
+		UploadURL: strings.TrimSuffix(url, "/"),
+		Token:     os.Getenv("MEDIA_UPLOAD_TOKEN"),
+		Dir:       dir,
+		client:    client,
+		uploaded:  map[string]uploadedFile{},
+	}, nil
+}
+
+// URI uploads <name>.wav unless this version already was, and returns
+// recording:<name>
+func (a *ARI) URI(ctx context.Context, name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("invalid media name %q", name)
+	}
+	path := filepath.Join(a.Dir, name+".wav")
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	version := uploadedFile{info.Size(), info.ModTime()}
+	a.mu.Lock()
+	done := a.uploaded[name] == version
+	a.mu.Unlock()
+	if done {
+		return "recording:" + name, nil
+	}
+
+	if err := a.upload(ctx, name, path); err != nil {
+		return "", err
+	}
+	key := ari.NewKey(ari.StoredRecordingKey, name)
+	if _, err := a.client.StoredRecording().Data(key); err != nil {
+		return "", fmt.Errorf("uploaded %s is not a stored recording of the ARI: %w", name, err)
+	}
+	a.mu.Lock()
+	a.uploaded[name] = version
+	a.mu.Unlock()
+	logging.For(ctx, "media").Debug("Uploaded to Asterisk", "recording", name, "bytes", version.size)
+	return "recording:" + name, nil
+}
+
+// Delete removes the stored recording of name from Asterisk
+func (a *ARI) Delete(ctx context.Context, name string) error {
+	a.mu.Lock()
+	delete(a.uploaded, name)
+	a.mu.Unlock()
+	return a.client.StoredRecording().Delete(ari.NewKey(ari.StoredRecordingKey, name))
+}
+
+func (a *ARI) upload(ctx context.Context, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	req, err := http.NewRequestWithContext(ctx, "PUT", a.UploadURL+"/"+name+".wav", file)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "audio/wav")
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload of %s: %w", name, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("upload of %s: media helper returned %s", name, resp.Status)
+	}
+	return nil
+}
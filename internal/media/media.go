@@ -0,0 +1,120 @@
+// Package media makes the audio generated in the TTS directory (answers,
+// prompts) playable by Asterisk: from a directory both share, downloaded from
+// the HTTP server of the IVR, or uploaded next to Asterisk, so they can run on
+// different hosts.
+package media
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ari/internal/tts"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+// Publisher returns the media URI playing a generated file
+type Publisher interface {
+	// URI returns the media URI of <name>.wav in the TTS directory
+	URI(ctx context.Context, name string) (string, error)
+}
+
+// FromEnv returns the publisher of MEDIA_TRANSPORT: "shared" (the default),
+// "http", served at MEDIA_URL, or "ari", uploaded to MEDIA_UPLOAD_URL
+func FromEnv(client ari.Client) (Publisher, error) {
+	switch transport := os.Getenv("MEDIA_TRANSPORT"); transport {
+	case "", "shared":
+		return Shared{}, nil
+	case "http":
+		return NewHTTP(os.Getenv("MEDIA_URL"), tts.Dir())
+	case "ari":
+		return NewARI(client, tts.Dir())
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_TRANSPORT %q", transport)
+	}
+}
+
+// Shared plays the files from the TTS directory, which is the recordings
+// directory of Asterisk
+type Shared struct{}
+
+func (Shared) URI(ctx context.Context, name string) (string, error) {
+	return "recording:" + name, nil
+}
+
+// HTTP serves the files of Dir, Asterisk downloads them from signed URLs
+// valid for linkTTL (res_http_media_cache)
+type HTTP struct {
+	BaseURL string // ex : http://ivr:8080/media
+	Dir     string
+
+	secret []byte
+}
+
+// linkTTL is how long a URL can be downloaded, the files play right away
+const linkTTL = 10 * time.Minute
+
+// NewHTTP serves dir, baseURL is where Asterisk reaches the /media/ route
+func NewHTTP(baseURL, dir string) (*HTTP, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("MEDIA_URL is required with MEDIA_TRANSPORT=http, ex : http://ivr:8080/media")
+	}
+	// The links are only signed and checked by this process
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &HTTP{BaseURL: strings.TrimSuffix(baseURL, "/"), Dir: dir, secret: secret}, nil
+}
+
+// URI returns sound:<BaseURL>/<expiry>/<signature>/<name>.wav, the extension
+// ending the path tells Asterisk the format
+func (h *HTTP) URI(ctx context.Context, name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("invalid media name %q", name)
+	}
+	exp := strconv.FormatInt(time.Now().Add(linkTTL).Unix(), 10)
+	return fmt.Sprintf("sound:%s/%s/%s/%s.wav", h.BaseURL, exp, h.sign(exp, name), name), nil
+}
+
+// ServeHTTP serves GET /media/<expiry>/<signature>/<name>.wav
+func (h *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/media/"), "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".wav") {
+		http.NotFound(w, r)
+		return
+	}
+	exp, sig, name := parts[0], parts[1], strings.TrimSuffix(parts[2], ".wav")
+	expiry, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !validName(name) || !hmac.Equal([]byte(sig), []byte(h.sign(exp, name))) {
+		http.NotFound(w, r)
+		return
+	}
+	if time.Now().Unix() > expiry {
+		http.Error(w, "link expired", http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	http.ServeFile(w, r, filepath.Join(h.Dir, name+".wav"))
+}
+
+func (h *HTTP) sign(exp, name string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(exp + "/" + name))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// validName rejects the names reaching outside of the directory
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".")
+}
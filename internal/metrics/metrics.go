@@ -0,0 +1,121 @@
+// Package metrics holds the Prometheus collectors of the IVR, exposed on /metrics
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Backend names used as label values
+const (
+	STT = "stt"
+	LLM = "llm"
+	TTS = "tts"
+)
+
+var (
+	StasisStart = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ivr_stasis_start_total",
+		Help: "Channels that entered the Stasis application.",
+	})
+	StasisEnd = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ivr_stasis_end_total",
+		Help: "Channels that left the Stasis application.",
+	})
+	ActiveCalls = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ivr_active_calls",
+		Help: "Calls currently handled.",
+	})
+	QueuedCalls = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ivr_queued_calls",
+		Help: "Calls on hold waiting for a free call slot.",
+	})
+	RejectedCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_rejected_calls_total",
+		Help: "Calls turned away without entering the menus, by reason.",
+	}, []string{"reason"})
+	Consent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_consent_total",
+		Help: "Answers of the callers to the recording notice, by decision (accepted, refused, notified or no answer).",
+	}, []string{"decision"})
+	DTMFSelections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_dtmf_selections_total",
+		Help: "Menu options selected by callers, by menu node and digit.",
+	}, []string{"menu", "digit"})
+	Recordings = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ivr_recordings_total",
+		Help: "Caller recordings started.",
+	})
+	BackendRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_backend_requests_total",
+		Help: "Requests sent to the speech and language backends.",
+	}, []string{"backend"})
+	BackendErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_backend_errors_total",
+		Help: "Failed requests to the speech and language backends.",
+	}, []string{"backend"})
+	BackendWaiting = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ivr_backend_waiting",
+		Help: "Requests waiting for a free slot of the speech and language backends.",
+	}, []string{"backend", "provider"})
+	BackendRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_backend_retries_total",
+		Help: "Requests to the speech and language backends retried after a transient error.",
+	}, []string{"backend", "provider"})
+	BackendCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ivr_backend_circuit_open",
+		Help: "1 while the circuit breaker of a backend is open.",
+	}, []string{"backend", "provider"})
+	BackendFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_backend_failovers_total",
+		Help: "Requests passed on to the next provider of a backend, by failed provider.",
+	}, []string{"backend", "provider"})
+	KnowledgeSearches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_knowledge_searches_total",
+		Help: "Searches of the knowledge base, by result (hit, miss or error).",
+	}, []string{"result"})
+	ToolCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_llm_tool_calls_total",
+		Help: "Tools called by the LLM, by tool and result (ok or error).",
+	}, []string{"tool", "result"})
+	TTSCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_tts_cache_requests_total",
+		Help: "Syntheses looked up in the TTS cache, by result (hit or miss).",
+	}, []string{"result"})
+	TTSCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ivr_tts_cache_bytes",
+		Help: "Size of the TTS cache after the last eviction.",
+	})
+	RetentionFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ivr_retention_files_total",
+		Help: "Call audio files handled by the retention policy, by action (archived, deleted, expired or failed).",
+	}, []string{"action"})
+	BackendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ivr_backend_duration_seconds",
+		Help:    "Latency of the speech and language backends.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 8, 13, 20},
+	}, []string{"backend"})
+	AnswerLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ivr_answer_latency_seconds",
+		Help:    "Time from the caller validating the recording to the answer starting to play.",
+		Buckets: []float64{0.5, 1, 2, 3, 5, 8, 13, 20, 30},
+	})
+)
+
+// ObserveBackend records one backend request started at start
+func ObserveBackend(backend string, start time.Time, err error) {
+	BackendRequests.WithLabelValues(backend).Inc()
+	BackendDuration.WithLabelValues(backend).Observe(time.Since(start).Seconds())
+	if err != nil {
+		BackendErrors.WithLabelValues(backend).Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"ari/internal/metrics"
+
+	"github.com/charmbracelet/log"
+)
+
+// ErrCircuitOpen is returned without sending the request while a provider is
+// considered down
+var ErrCircuitOpen = errors.New("circuit open, provider considered down")
+
+// breaker opens after threshold failed requests in a row. Once cooldown has
+// passed, one trial request is let through: its success closes the circuit,
+// its failure opens it again.
+type breaker struct {
+	name      string
+	provider  string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // a trial request is in flight
+}
+
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return fmt.Errorf("%s %s: %w", b.name, b.provider, ErrCircuitOpen)
+	}
+	b.trial = true
+	return nil
+}
+
+// done records the outcome of a request let through by allow
+func (b *breaker) done(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+
+	switch {
+	case err == nil, IsPermanent(err):
+		if b.failures >= b.threshold {
+			log.Info("Circuit closed, provider is back", "backend", b.name, "provider", b.provider)
+			metrics.BackendCircuitOpen.WithLabelValues(b.name, b.provider).Set(0)
+		}
+		b.failures = 0
+	case callContext(ctx).Err() != nil:
+		// The call went away, this says nothing about the provider. A provider
+		// over the latency budget does count as failed.
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			if b.failures == b.threshold {
+				log.Error("Circuit open, provider considered down", "backend", b.name, "provider", b.provider, "failures", b.failures, "cooldown", b.cooldown)
+			}
+			b.openUntil = time.Now().Add(b.cooldown)
+			metrics.BackendCircuitOpen.WithLabelValues(b.name, b.provider).Set(1)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ari/internal/logging"
+	"ari/internal/metrics"
+)
+
+// Chain is the ordered list of providers of a backend: a request falls
+// through to the next provider when one fails or is over the latency budget.
+type Chain struct {
+	Name      string        // backend name, ex : metrics.STT
+	Providers []string      // in order of preference
+	Budget    time.Duration // latency budget of every provider but the last, none when 0
+}
+
+// ChainFromEnv returns the chain of providers of a backend, with the latency
+// budget <NAME>_BUDGET
+func ChainFromEnv(name string, providers []string) (*Chain, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("%s: no provider", name)
+	}
+	budget, err := envDuration(strings.ToUpper(name)+"_BUDGET", 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Chain{Name: name, Providers: providers, Budget: budget}, nil
+}
+
+// ParseProviders splits a list like "deepgram,vosk", def when it is empty
+func ParseProviders(list, def string) []string {
+	if strings.TrimSpace(list) == "" {
+		list = def
+	}
+	var providers []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// Do calls fn with the index of each provider in turn, until one succeeds.
+// The provider which served the request is recorded in the Served of ctx.
+func (c *Chain) Do(ctx context.Context, fn func(ctx context.Context, provider int) error) error {
+	var errs []error
+	for i, provider := range c.Providers {
+		last := i == len(c.Providers)-1
+		providerCtx, cancel := ctx, context.CancelFunc(func() {})
+		if c.Budget > 0 && !last {
+			providerCtx, cancel = withBudget(ctx, c.Budget)
+		}
+		err := fn(providerCtx, i)
+		overBudget := err != nil && ctx.Err() == nil && errors.Is(providerCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if err == nil {
+			servedFrom(ctx).set(c.Name, provider)
+			return nil
+		}
+		if ctx.Err() != nil || IsPartial(err) {
+			// The call went away, or heard part of the result: no use asking another provider
+			return err
+		}
+		if overBudget {
+			err = fmt.Errorf("over the latency budget of %s: %w", c.Budget, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider, err))
+		if !last {
+			logging.For(ctx, "policy").Warn("Provider failed, falling through",
+				"backend", c.Name, "provider", provider, "next", c.Providers[i+1], "err", err)
+			metrics.BackendFailovers.WithLabelValues(c.Name, provider).Inc()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type callKey struct{}
+
+// withBudget returns ctx cancelled after the latency budget d, which keeps
+// ctx as the context of the call: the provider is blamed for the budget
+// expiring, not for the call going away
+func withBudget(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	budgetCtx, cancel := context.WithTimeout(ctx, d)
+	return context.WithValue(budgetCtx, callKey{}, ctx), cancel
+}
+
+// callContext returns the context of the call, ctx without the latency budget
+func callContext(ctx context.Context) context.Context {
+	if call, ok := ctx.Value(callKey{}).(context.Context); ok {
+		return call
+	}
+	return ctx
+}
+
+type servedKey struct{}
+
+// Served records which provider served the requests of each backend
+type Served struct {
+	mu        sync.Mutex
+	providers map[string]string // backend -> provider
+}
+
+// TrackServed returns a context recording the providers serving its requests
+func TrackServed(ctx context.Context) (context.Context, *Served) {
+	s := &Served{providers: map[string]string{}}
+	return context.WithValue(ctx, servedKey{}, s), s
+}
+
+func servedFrom(ctx context.Context) *Served {
+	s, _ := ctx.Value(servedKey{}).(*Served)
+	return s
+}
+
+func (s *Served) set(backend, provider string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.providers[backend] = provider
+}
+
+// Provider returns the provider which served the last request of backend, ""
+// when none did
+func (s *Served) Provider(backend string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.providers[backend]
+}
+
+// Ping succeeds when ping succeeds for one of the providers
+func (c *Chain) Ping(ctx context.Context, ping func(ctx context.Context, provider int) error) error {
+	var errs []error
+	for i, provider := range c.Providers {
+		err := ping(ctx, i)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider, err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,52 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudgetTripsBreaker(t *testing.T) {
+	slow := &Policy{Name: "stt", Provider: "slow", breaker: &breaker{name: "stt", provider: "slow", threshold: 2, cooldown: time.Minute}}
+	chain := &Chain{Name: "stt", Providers: []string{"slow", "fast"}, Budget: 10 * time.Millisecond}
+	request := func(ctx context.Context) error {
+		return chain.Do(ctx, func(ctx context.Context, provider int) error {
+			if provider == 1 {
+				return nil
+			}
+			return slow.Do(ctx, func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		})
+	}
+
+	for i := range 2 {
+		if err := request(context.Background()); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if err := slow.breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("after 2 requests over the budget: got %v, want the circuit open", err)
+	}
+}
+
+func TestCallGoneSparesBreaker(t *testing.T) {
+	p := &Policy{Name: "stt", Provider: "slow", breaker: &breaker{name: "stt", provider: "slow", threshold: 1, cooldown: time.Minute}}
+	chain := &Chain{Name: "stt", Providers: []string{"slow", "fast"}, Budget: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := chain.Do(ctx, func(ctx context.Context, provider int) error {
+		return p.Do(ctx, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	})
+	if err == nil {
+		t.Fatal("the call went away: no error")
+	}
+	if err := p.breaker.allow(); err != nil {
+		t.Errorf("the call went away: got %v, want the circuit closed", err)
+	}
+}
@@ -0,0 +1,223 @@
+// Package policy guards the requests sent to the speech and language backends:
+// concurrency limit, so a traffic spike cannot exhaust the provider quotas,
+// timeout and retries of transient errors, and a circuit breaker failing fast
+// while a provider is down.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ari/internal/logging"
+	"ari/internal/metrics"
+)
+
+// Policy applies to every request of one provider of a backend
+type Policy struct {
+	Name     string        // backend name, ex : metrics.STT
+	Provider string        // ex : deepgram
+	Timeout  time.Duration // of each attempt, none when 0
+	Retries  int           // attempts after the first one, for transient errors
+	Backoff  time.Duration // delay before the first retry, doubled at each retry, with jitter
+
+	sem     chan struct{} // concurrent requests, nil when unlimited
+	breaker *breaker      // nil when disabled
+}
+
+// FromEnv returns the policy of a provider of a backend configured by the
+// environment, <NAME>_<PROVIDER>_X overriding <NAME>_X:
+//
+//	<NAME>_MAX_CONCURRENCY: requests in flight at once, unlimited when 0 or unset
+//	<NAME>_TIMEOUT: timeout of each attempt (20s by default)
+//	<NAME>_RETRIES: retries of transient errors (2 by default)
+//	<NAME>_BREAKER_FAILURES: failed requests in a row opening the circuit (5 by default, 0 disables it)
+//	<NAME>_BREAKER_COOLDOWN: time the circuit stays open before a trial request (30s by default)
+func FromEnv(name, provider string) (*Policy, error) {
+	env := envLookup(name, provider)
+	p := &Policy{Name: name, Provider: provider, Backoff: 200 * time.Millisecond}
+
+	concurrency, err := envInt(env("MAX_CONCURRENCY"), 0)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency > 0 {
+		p.sem = make(chan struct{}, concurrency)
+	}
+	if p.Timeout, err = envDuration(env("TIMEOUT"), 20*time.Second); err != nil {
+		return nil, err
+	}
+	if p.Retries, err = envInt(env("RETRIES"), 2); err != nil {
+		return nil, err
+	}
+	failures, err := envInt(env("BREAKER_FAILURES"), 5)
+	if err != nil {
+		return nil, err
+	}
+	cooldown, err := envDuration(env("BREAKER_COOLDOWN"), 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if failures > 0 {
+		p.breaker = &breaker{name: name, provider: provider, threshold: failures, cooldown: cooldown}
+	}
+	return p, nil
+}
+
+// envLookup returns the name of the variable setting of a provider: the
+// provider one when it is set, the backend one otherwise
+func envLookup(name, provider string) func(setting string) string {
+	prefix := strings.ToUpper(name)
+	specific := prefix + "_" + strings.ToUpper(provider)
+	return func(setting string) string {
+		if _, ok := os.LookupEnv(specific + "_" + setting); ok && provider != "" {
+			return specific + "_" + setting
+		}
+		return prefix + "_" + setting
+	}
+}
+
+// Do runs the request fn once a slot is free, retrying transient errors
+func (p *Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := p.breaker.allow(); err != nil {
+		return err
+	}
+
+	if p.sem != nil {
+		metrics.BackendWaiting.WithLabelValues(p.Name, p.Provider).Inc()
+		select {
+		case p.sem <- struct{}{}:
+			metrics.BackendWaiting.WithLabelValues(p.Name, p.Provider).Dec()
+		case <-ctx.Done():
+			metrics.BackendWaiting.WithLabelValues(p.Name, p.Provider).Dec()
+			p.breaker.done(ctx, ctx.Err())
+			return fmt.Errorf("%s %s: waiting for a free slot: %w", p.Name, p.Provider, ctx.Err())
+		}
+		defer func() { <-p.sem }()
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = p.attempt(ctx, fn)
+		if err == nil || attempt >= p.Retries || IsPermanent(err) || IsPartial(err) || ctx.Err() != nil {
+			break
+		}
+		// Full jitter, so the calls hit by the same outage do not retry together
+		var delay time.Duration
+		if p.Backoff > 0 {
+			delay = rand.N(p.Backoff << attempt)
+		}
+		logging.For(ctx, "policy").Warn("Backend request failed, retrying", "backend", p.Name, "provider", p.Provider, "attempt", attempt+1, "delay", delay, "err", err)
+		metrics.BackendRetries.WithLabelValues(p.Name, p.Provider).Inc()
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	p.breaker.done(ctx, err)
+	return err
+}
+
+func (p *Policy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.Timeout == 0 {
+		return fn(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	err := fn(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s %s: timeout after %s: %w", p.Name, p.Provider, p.Timeout, err)
+	}
+	return err
+}
+
+// permanentError is an error retrying cannot fix, ex : an invalid request
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying. It is about the request, not the
+// provider, so it does not count as a failure of the provider either.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// partialError is the error of a request which already delivered part of
+// its result
+type partialError struct {
+	err error
+}
+
+func (e partialError) Error() string { return e.err.Error() }
+
+func (e partialError) Unwrap() error { return e.err }
+
+// Partial marks the error of a request which already delivered part of its
+// result, ex : a streamed answer the caller started to hear. It is neither
+// retried nor sent to another provider, which would start over.
+func Partial(err error) error {
+	if err == nil {
+		return nil
+	}
+	return partialError{err}
+}
+
+// IsPartial reports whether err was marked with Partial
+func IsPartial(err error) bool {
+	var p partialError
+	return errors.As(err, &p)
+}
+
+// PermanentStatus reports whether an HTTP status of a provider is about the
+// request itself (ex : 400 Bad Request), so retrying cannot fix it
+func PermanentStatus(code int) bool {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		// Key or quota of the provider, or a transient error
+		return false
+	}
+	return code >= 400 && code < 500
+}
+
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return d, nil
+}
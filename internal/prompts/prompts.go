@@ -0,0 +1,198 @@
+// Package prompts renders the menu prompts from text templates through the TTS
+// backend, so changing a prompt is a config edit instead of a new recording.
+// Prompts without a template keep their pre-recorded sound.
+package prompts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"ari/internal/media"
+	"ari/internal/tts"
+
+	"github.com/charmbracelet/log"
+)
+
+// Vars are the variables of the templates, ex : "Good {{.TimeOfDay}} {{.CallerName}}"
+type Vars struct {
+	CallID     string // the renders which depend on the call are its own
+	CallerID   string
+	CallerName string
+	Exten      string
+	TimeOfDay  string // morning, afternoon or evening
+	Now        time.Time
+}
+
+// NewVars returns the variables of a call at the current time
+func NewVars(callID, callerID, callerName, exten string) Vars {
+	now := time.Now()
+	v := Vars{CallID: callID, CallerID: callerID, CallerName: callerName, Exten: exten, Now: now, TimeOfDay: "evening"}
+	switch h := now.Hour(); {
+	case h < 12:
+		v.TimeOfDay = "morning"
+	case h < 18:
+		v.TimeOfDay = "afternoon"
+	}
+	return v
+}
+
+// Prompts renders the templates of a prompts file into WAV files of Dir,
+// named after the prompt and a hash of the text, so a text is synthesized once
+type Prompts struct {
+	Dir string // where the generated audio is written, see media
+
+	templates map[string]*template.Template
+	tts       tts.Synthesizer
+	media     media.Publisher
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{} // file -> closed once rendered
+}
+
+// Load reads the templates of PROMPTS_FILE, a JSON object of prompt name to
+// template, ex :
+//
+//	{"welcome-ari": "Good {{.TimeOfDay}}. Press 1 to record your question."}
+//
+// Without PROMPTS_FILE every prompt keeps its pre-recorded sound.
+func Load(synthesizer tts.Synthesizer, publisher media.Publisher) (*Prompts, error) {
+	p := &Prompts{
+		Dir:       tts.Dir(),
+		templates: map[string]*template.Template{},
+		tts:       synthesizer,
+		media:     publisher,
+		inflight:  map[string]chan struct{}{},
+	}
+	path := os.Getenv("PROMPTS_FILE")
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var texts map[string]string
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, text := range texts {
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: prompt %s: %w", path, name, err)
+		}
+		p.templates[name] = t
+	}
+	return p, nil
+}
+
+// Warm renders the prompts which do not depend on the call, so the first
+// caller does not wait for them
+func (p *Prompts) Warm(ctx context.Context) {
+	for name, t := range p.templates {
+		if !static(t) {
+			continue
+		}
+		if _, _, err := p.URI(ctx, name, Vars{}); err != nil {
+			log.Warn("Cannot render the prompt, the pre-recorded sound will play", "prompt", name, "err", err)
+		}
+	}
+}
+
+// URI returns the media of a prompt for a call: the rendered template, or
+// sound:<name> without template. The error comes with the sound:<name>
+// fallback when the rendering failed. A template depending on the call is
+// rendered to a file of the call, callFile, to delete with its audio as it
+// may hold the caller name; the other renders are shared by the calls.
+func (p *Prompts) URI(ctx context.Context, name string, vars Vars) (uri string, callFile string, err error) {
+	fallback := "sound:" + name
+	if p == nil {
+		return fallback, "", nil
+	}
+	t, ok := p.templates[name]
+	if !ok {
+		return fallback, "", nil
+	}
+	var text strings.Builder
+	if err := t.Execute(&text, vars); err != nil {
+		return fallback, "", err
+	}
+	key := text.String()
+	if !static(t) {
+		// Ex : the caller name, kept out of the TTS cache
+		ctx = tts.Private(ctx)
+		key = vars.CallID + "\x00" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	file := fmt.Sprintf("prompt_%s_%s", name, hex.EncodeToString(sum[:6]))
+	if !static(t) {
+		callFile = file
+	}
+	if err := p.render(ctx, text.String(), file); err != nil {
+		return fallback, "", err
+	}
+	if uri, err = p.media.URI(ctx, file); err != nil {
+		return fallback, callFile, err
+	}
+	return uri, callFile, nil
+}
+
+// render synthesizes text into file once, concurrent callers wait for it
+func (p *Prompts) render(ctx context.Context, text, file string) error {
+	path := filepath.Join(p.Dir, file+".wav")
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		p.mu.Lock()
+		done, busy := p.inflight[file]
+		if !busy {
+			done = make(chan struct{})
+			p.inflight[file] = done
+		}
+		p.mu.Unlock()
+		if !busy {
+			break
+		}
+		select {
+		case <-done:
+			// Rendered, or failed and ours to try
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer func() {
+		p.mu.Lock()
+		done := p.inflight[file]
+		delete(p.inflight, file)
+		p.mu.Unlock()
+		close(done)
+	}()
+
+	// Asterisk must never play a partial file
+	tmp := filepath.Join(p.Dir, "."+file+".tmp.wav")
+	if err := p.tts.SynthesizeFile(ctx, text, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// static reports whether the template renders the same text for every call
+func static(t *template.Template) bool {
+	for _, node := range t.Tree.Root.Nodes {
+		if node.Type() != parse.NodeText {
+			return false
+		}
+	}
+	return true
+}
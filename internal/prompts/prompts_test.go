@@ -0,0 +1,58 @@
+package prompts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ari/internal/media"
+	"ari/internal/tts"
+)
+
+func TestURICallFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prompts.json")
+	data := `{"after_recording": "Press 3 to send.", "welcome-ari": "Hello {{.CallerName}}."}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROMPTS_FILE", path)
+	t.Setenv("TTS_DIR", dir)
+	p, err := Load(&tts.Fake{WordDuration: 10 * time.Millisecond}, media.Shared{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	uri, callFile, err := p.URI(ctx, "after_recording", NewVars("call-1", "1000", "Alice", "100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callFile != "" {
+		t.Errorf("static prompt: got the call file %q", callFile)
+	}
+	if other, _, _ := p.URI(ctx, "after_recording", NewVars("call-2", "1000", "Alice", "100")); other != uri {
+		t.Errorf("static prompt: %q for a call, %q for another", uri, other)
+	}
+
+	_, first, err := p.URI(ctx, "welcome-ari", NewVars("call-1", "1000", "Alice", "100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := p.URI(ctx, "welcome-ari", NewVars("call-2", "1000", "Alice", "100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == "" || first == second {
+		t.Errorf("prompt with the caller name: call files %q and %q, want one per call", first, second)
+	}
+	if _, err := os.Stat(filepath.Join(dir, first+".wav")); err != nil {
+		t.Error(err)
+	}
+
+	if uri, callFile, err := p.URI(ctx, "ari_goodbye", Vars{}); uri != "sound:ari_goodbye" || callFile != "" || err != nil {
+		t.Errorf("without template: got %q, %q, %v", uri, callFile, err)
+	}
+}
@@ -0,0 +1,267 @@
+// Package redact finds the personal data in the transcripts and the answers:
+// credit card numbers, phone numbers, emails, IBANs and spoken digit
+// sequences. It masks them for the logs and the stored records, and can swap
+// them for placeholders in the LLM prompt, the originals staying in the call
+// for trusted tools.
+package redact
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kind is a kind of personal data
+type Kind string
+
+const (
+	Card   Kind = "card"
+	Phone  Kind = "phone"
+	Email  Kind = "email"
+	IBAN   Kind = "iban"
+	Digits Kind = "digits" // account numbers, codes, spoken digit by digit
+)
+
+var allKinds = []Kind{Card, Phone, Email, IBAN, Digits}
+
+// Match is personal data found in a text, text[Start:End]
+type Match struct {
+	Kind  Kind
+	Start int
+	End   int
+}
+
+// Redactor finds the kinds of personal data it is set for. Its methods return
+// the text unchanged on a nil Redactor.
+type Redactor struct {
+	Kinds []Kind
+	// LLMPlaceholders swaps the personal data for placeholders in the LLM prompt
+	LLMPlaceholders bool
+}
+
+// FromEnv returns the redactor of REDACT, the kinds of data comma separated
+// (all of them by default, none disables it), and REDACT_LLM=placeholders
+func FromEnv() (*Redactor, error) {
+	r := &Redactor{Kinds: allKinds}
+	switch spec := os.Getenv("REDACT"); spec {
+	case "", "all":
+	case "none":
+		return nil, nil
+	default:
+		r.Kinds = nil
+		for _, k := range strings.Split(spec, ",") {
+			kind := Kind(strings.TrimSpace(k))
+			if !slices.Contains(allKinds, kind) {
+				return nil, fmt.Errorf("unknown kind %q in REDACT, expected card, phone, email, iban or digits", kind)
+			}
+			r.Kinds = append(r.Kinds, kind)
+		}
+	}
+	switch mode := os.Getenv("REDACT_LLM"); mode {
+	case "", "off":
+	case "placeholders":
+		r.LLMPlaceholders = true
+	default:
+		return nil, fmt.Errorf("unknown REDACT_LLM %q, expected off or placeholders", mode)
+	}
+	return r, nil
+}
+
+var (
+	emailRe = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	// As transcribed : "john dot doe at example dot com"
+	spokenEmailRe = regexp.MustCompile(`(?i)\b[a-z0-9]+(?: (?:dot|underscore|dash) [a-z0-9]+)* at [a-z0-9-]+(?: dot [a-z0-9-]+)* dot [a-z]{2,}\b`)
+	ibanRe        = regexp.MustCompile(`(?i)\b[a-z]{2}\d{2}(?: ?[a-z0-9]){11,30}\b`)
+	// Digits with single separators, ex : 4111 1111-1111.1111, +33 6 12 34 56 78
+	numberRe = regexp.MustCompile(`\+?\(?\d(?:[ .()-]{0,2}\d){5,}`)
+	// 2024-05-12, 12.05.2024
+	dateRe = regexp.MustCompile(`^(?:(\d{4})[-.](\d{1,2})[-.](\d{1,2})|(\d{1,2})[-.](\d{1,2})[-.](\d{4}))$`)
+	// A currency before or after an amount : $1 250, 150 000 euros
+	currencyBeforeRe = regexp.MustCompile(`(?:[$€£]|\b(?:EUR|USD|GBP))\s?$`)
+	currencyAfterRe  = regexp.MustCompile(`(?i)^\s?(?:[$€£]|(?:euros?|dollars?|pounds?|eur|usd|gbp)\b)`)
+	// Digits said one by one, "four one one one", at least four of them
+	spokenRe = regexp.MustCompile(`(?i)\b(?:(?:zero|oh|one|two|three|four|five|six|seven|eight|nine)[ ,-]+){3,}(?:zero|oh|one|two|three|four|five|six|seven|eight|nine)\b`)
+)
+
+var spokenDigits = map[string]byte{
+	"zero": '0', "oh": '0', "one": '1', "two": '2', "three": '3', "four": '4',
+	"five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+}
+
+// Find returns the personal data of text, in order and without overlaps
+func (r *Redactor) Find(text string) []Match {
+	if r == nil {
+		return nil
+	}
+	var matches []Match
+	add := func(kind Kind, start, end int) {
+		if !slices.Contains(r.Kinds, kind) {
+			return
+		}
+		for _, m := range matches {
+			if start < m.End && m.Start < end {
+				return
+			}
+		}
+		matches = append(matches, Match{Kind: kind, Start: start, End: end})
+	}
+
+	for _, loc := range emailRe.FindAllStringIndex(text, -1) {
+		add(Email, loc[0], loc[1])
+	}
+	for _, loc := range spokenEmailRe.FindAllStringIndex(text, -1) {
+		add(Email, loc[0], loc[1])
+	}
+	for _, loc := range ibanRe.FindAllStringIndex(text, -1) {
+		if end := validIBAN(text, loc[0], loc[1]); end > 0 {
+			add(IBAN, loc[0], end)
+		}
+	}
+	for _, loc := range numberRe.FindAllStringIndex(text, -1) {
+		if isDate(text[loc[0]:loc[1]]) || isAmount(text, loc[0], loc[1]) {
+			continue
+		}
+		if kind, ok := classify(onlyDigits(text[loc[0]:loc[1]]), 6); ok {
+			add(kind, loc[0], loc[1])
+		}
+	}
+	for _, loc := range spokenRe.FindAllStringIndex(text, -1) {
+		var digits []byte
+		for _, w := range strings.FieldsFunc(strings.ToLower(text[loc[0]:loc[1]]), isSeparator) {
+			digits = append(digits, spokenDigits[w])
+		}
+		if kind, ok := classify(string(digits), 4); ok {
+			add(kind, loc[0], loc[1])
+		}
+	}
+
+	slices.SortFunc(matches, func(a, b Match) int { return a.Start - b.Start })
+	return matches
+}
+
+// Mask replaces the personal data of text with its kind, ex : [CARD]
+func (r *Redactor) Mask(text string) string {
+	return r.replace(text, func(m Match) string {
+		return "[" + strings.ToUpper(string(m.Kind)) + "]"
+	})
+}
+
+func (r *Redactor) replace(text string, with func(Match) string) string {
+	matches := r.Find(text)
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m.Start])
+		b.WriteString(with(m))
+		last = m.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// classify tells what a run of digits is, shorter than min it is nothing
+func classify(digits string, min int) (Kind, bool) {
+	switch n := len(digits); {
+	case n >= 13 && n <= 19 && luhn(digits):
+		return Card, true
+	case n >= 8 && n <= 15:
+		return Phone, true
+	case n >= min:
+		return Digits, true
+	}
+	return "", false
+}
+
+// isDate reports whether a number is a date, year first or last
+func isDate(number string) bool {
+	m := dateRe.FindStringSubmatch(number)
+	if m == nil {
+		return false
+	}
+	day, month := m[3], m[2]
+	if m[1] == "" {
+		day, month = m[4], m[5]
+	}
+	d, _ := strconv.Atoi(day)
+	mo, _ := strconv.Atoi(month)
+	return d >= 1 && d <= 31 && mo >= 1 && mo <= 12
+}
+
+// isAmount reports whether the number text[start:end] is a sum of money
+func isAmount(text string, start, end int) bool {
+	return currencyBeforeRe.MatchString(text[:start]) || currencyAfterRe.MatchString(text[end:])
+}
+
+// luhn checks the check digit of a card number
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validIBAN returns the end of the valid IBAN starting at text[start:], which
+// the match may overrun with the next words, 0 when there is none
+func validIBAN(text string, start, end int) int {
+	for ; end-start >= 15; end-- {
+		if text[end-1] == ' ' {
+			continue
+		}
+		if end < len(text) && isAlnum(text[end]) {
+			continue
+		}
+		compact := strings.ToUpper(strings.ReplaceAll(text[start:end], " ", ""))
+		if len(compact) >= 15 && len(compact) <= 34 && ibanChecksum(compact) {
+			return end
+		}
+	}
+	return 0
+}
+
+// ibanChecksum checks the ISO 13616 mod 97 checksum
+func ibanChecksum(iban string) bool {
+	var b strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		if c >= 'A' && c <= 'Z' {
+			b.WriteString(strconv.Itoa(int(c-'A') + 10))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	n, ok := new(big.Int).SetString(b.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func isSeparator(r rune) bool {
+	return r == ' ' || r == ',' || r == '-'
+}
+
+func isAlnum(c byte) bool {
+	return c < 128 && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)))
+}
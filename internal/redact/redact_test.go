@@ -0,0 +1,99 @@
+package redact
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	r := &Redactor{Kinds: allKinds}
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		// Personal data
+		{"my card is 4111 1111 1111 1111", "my card is [CARD]"},
+		{"card 4111-1111-1111-1111, thanks", "card [CARD], thanks"},
+		{"call me at +33 6 12 34 56 78", "call me at [PHONE]"},
+		{"call me at 06 12 34 56 78", "call me at [PHONE]"},
+		{"my number is 555-123-4567", "my number is [PHONE]"},
+		{"write to john.doe@example.com please", "write to [EMAIL] please"},
+		{"it is john dot doe at example dot com", "it is [EMAIL]"},
+		{"IBAN FR76 3000 6000 0112 3456 7890 189 thanks", "IBAN [IBAN] thanks"},
+		{"GB82WEST12345698765432", "[IBAN]"},
+		{"my code is four one one one", "my code is [DIGITS]"},
+		{"account 123456", "account [DIGITS]"},
+		// Not personal data
+		{"we are open from 9 to 5", "we are open from 9 to 5"},
+		{"call between 09:00 and 18:00", "call between 09:00 and 18:00"},
+		{"the meeting is on 2024-05-12", "the meeting is on 2024-05-12"},
+		{"delivered on 12.05.2024", "delivered on 12.05.2024"},
+		{"on 12/05/2024 at 10:30", "on 12/05/2024 at 10:30"},
+		{"it costs 1,299.99 euros", "it costs 1,299.99 euros"},
+		{"a loan of 150 000 euros", "a loan of 150 000 euros"},
+		{"a loan of 1 500 000 EUR", "a loan of 1 500 000 EUR"},
+		{"it costs $1 250 000", "it costs $1 250 000"},
+		{"order 1234 is shipped", "order 1234 is shipped"},
+		{"order A-12345 is shipped", "order A-12345 is shipped"},
+		{"one two three", "one two three"},
+		// Wrong checksums are not cards or IBANs, the digits are masked still
+		{"FR76 3000 6000 0112 3456 7890 188", "FR[DIGITS]"},
+		{"card 4111 1111 1111 1112", "card [DIGITS]"},
+	} {
+		if got := r.Mask(tc.text); got != tc.want {
+			t.Errorf("Mask(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestFindKinds(t *testing.T) {
+	r := &Redactor{Kinds: []Kind{Email}}
+	text := "mail jo@example.com or call 06 12 34 56 78"
+	got := r.Find(text)
+	if len(got) != 1 || got[0] != (Match{Kind: Email, Start: 5, End: 19}) {
+		t.Errorf("only emails: got %v", got)
+	}
+	var none *Redactor
+	if got := none.Find(text); got != nil {
+		t.Errorf("nil redactor: got %v", got)
+	}
+	if got := none.Mask(text); got != text {
+		t.Errorf("nil redactor: got %q", got)
+	}
+}
+
+func TestPlaceholdersRestore(t *testing.T) {
+	r := &Redactor{Kinds: allKinds}
+	v := NewValues()
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		{"mail jo@example.com or call 06 12 34 56 78", "mail [EMAIL_1] or call [PHONE_1]"},
+		// The same value keeps its placeholder from one turn to the next
+		{"again jo@example.com, or al@example.com", "again [EMAIL_1], or [EMAIL_2]"},
+		{"nothing personal", "nothing personal"},
+	} {
+		got := r.Placeholders(tc.text, v)
+		if got != tc.want {
+			t.Errorf("Placeholders(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+		if restored := v.Restore(got); restored != tc.text {
+			t.Errorf("Restore(%q) = %q, want %q", got, restored, tc.text)
+		}
+	}
+
+	if got := v.Restore("[EMAIL_3] and [CARD_1] are unknown"); got != "[EMAIL_3] and [CARD_1] are unknown" {
+		t.Errorf("unknown placeholders: got %q", got)
+	}
+	if value, ok := v.Reveal("[PHONE_1]"); !ok || value != "06 12 34 56 78" {
+		t.Errorf("Reveal: got %q, %v", value, ok)
+	}
+	var none *Values
+	if got := none.Restore("[EMAIL_1]"); got != "[EMAIL_1]" {
+		t.Errorf("nil values: got %q", got)
+	}
+	if ValuesFrom(WithValues(context.Background(), v)) != v {
+		t.Error("the values are not carried by the context")
+	}
+}
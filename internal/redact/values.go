@@ -0,0 +1,89 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Values holds the personal data swapped for placeholders during a call, so
+// the same value keeps its placeholder from one turn to the next
+type Values struct {
+	mu            sync.Mutex
+	byPlaceholder map[string]string
+	byValue       map[string]string
+	counts        map[Kind]int
+}
+
+// NewValues returns the values of a call
+func NewValues() *Values {
+	return &Values{
+		byPlaceholder: map[string]string{},
+		byValue:       map[string]string{},
+		counts:        map[Kind]int{},
+	}
+}
+
+// Placeholders replaces the personal data of text with placeholders like
+// [CARD_1], noted in v
+func (r *Redactor) Placeholders(text string, v *Values) string {
+	return r.replace(text, func(m Match) string {
+		return v.placeholder(m.Kind, text[m.Start:m.End])
+	})
+}
+
+func (v *Values) placeholder(kind Kind, value string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if p, ok := v.byValue[value]; ok {
+		return p
+	}
+	v.counts[kind]++
+	p := fmt.Sprintf("[%s_%d]", strings.ToUpper(string(kind)), v.counts[kind])
+	v.byValue[value] = p
+	v.byPlaceholder[p] = value
+	return p
+}
+
+var placeholderRe = regexp.MustCompile(`\[(?:CARD|PHONE|EMAIL|IBAN|DIGITS)_\d+\]`)
+
+// Restore puts the original values back in place of the placeholders of text
+func (v *Values) Restore(text string) string {
+	if v == nil {
+		return text
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return placeholderRe.ReplaceAllStringFunc(text, func(p string) string {
+		if value, ok := v.byPlaceholder[p]; ok {
+			return value
+		}
+		return p
+	})
+}
+
+// Reveal returns the original value of a placeholder, for trusted tools
+func (v *Values) Reveal(placeholder string) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	value, ok := v.byPlaceholder[placeholder]
+	return value, ok
+}
+
+type valuesKey struct{}
+
+// WithValues returns ctx carrying the values of the call
+func WithValues(ctx context.Context, v *Values) context.Context {
+	return context.WithValue(ctx, valuesKey{}, v)
+}
+
+// ValuesFrom returns the values of the call of ctx, nil outside of a call
+func ValuesFrom(ctx context.Context) *Values {
+	v, _ := ctx.Value(valuesKey{}).(*Values)
+	return v
+}
@@ -0,0 +1,313 @@
+// Package retention decides what becomes of the audio of a call once it ended:
+// moved to the archive together with the call record, left in place, or
+// deleted. It also deletes the audio older than the retention period, so the
+// recordings directory and the archive do not grow forever.
+package retention
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ari/internal/archive"
+	"ari/internal/ariutil"
+	"ari/internal/cdr"
+	"ari/internal/logging"
+	"ari/internal/metrics"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+// Which calls keep their audio (RETENTION_KEEP)
+const (
+	KeepAll     = "all"
+	KeepFlagged = "flagged" // only the calls flagged for review
+	KeepNone    = "none"
+)
+
+// Name prefixes of the audio of the calls, the caller recordings
+// (msg_<channel>_<unix>) and the messages of the admin API
+// (admin_<channel>_<unix nano>_tts)
+var callAudio = []string{"msg_", "admin_"}
+
+// sweepInterval is how often the audio past MaxAge is looked for
+const sweepInterval = time.Hour
+
+// Retention applies the retention policy to the audio of the calls
+type Retention struct {
+	Keep    string
+	MaxAge  time.Duration // 0 keeps the audio forever
+	Archive archive.Store // nil leaves the kept audio in place
+	Dir     string        // where the audio is generated, the TTS directory
+
+	client ari.Client
+	// remover deletes the copies of the generated audio next to Asterisk
+	remover interface {
+		Delete(ctx context.Context, name string) error
+	}
+}
+
+// New returns the policy of RETENTION_KEEP (all by default, flagged or none)
+// and RETENTION_MAX_AGE (0, forever, by default). publisher is checked for
+// the copies of the generated audio to delete along with the files of dir.
+func New(client ari.Client, store archive.Store, dir string, publisher any) (*Retention, error) {
+	keep := os.Getenv("RETENTION_KEEP")
+	switch keep {
+	case "":
+		keep = KeepAll
+	case KeepAll, KeepFlagged, KeepNone:
+	default:
+		return nil, fmt.Errorf("unknown RETENTION_KEEP %q, expected all, flagged or none", keep)
+	}
+	var maxAge time.Duration
+	if v := os.Getenv("RETENTION_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid RETENTION_MAX_AGE %q, expected a duration like 720h", v)
+		}
+		maxAge = d
+	}
+	r := &Retention{Keep: keep, MaxAge: maxAge, Archive: store, Dir: dir, client: client}
+	if remover, ok := publisher.(interface {
+		Delete(ctx context.Context, name string) error
+	}); ok {
+		r.remover = remover
+	}
+	return r, nil
+}
+
+// Call is the audio of an ended call
+type Call struct {
+	Record     *cdr.Record
+	Recordings []string // stored recordings of Asterisk, what the caller said
+	Files      []string // audio generated in Dir, without the .wav extension
+}
+
+// AfterCall archives or deletes the audio of the call. Archived, the files go
+// under archive.CallPrefix with the record as record.json, written last, and
+// the prefix is noted in the record. The originals are kept when the archive
+// fails.
+func (r *Retention) AfterCall(ctx context.Context, call Call) error {
+	if r == nil {
+		return nil
+	}
+	if !r.keeps(call.Record) {
+		r.remove(ctx, call, "deleted")
+		return nil
+	}
+	if r.Archive == nil {
+		return nil
+	}
+	start := time.Now()
+	if err := r.archive(ctx, call); err != nil {
+		call.Record.Archive = ""
+		metrics.RetentionFiles.WithLabelValues("failed").Add(float64(len(call.Recordings) + len(call.Files)))
+		return fmt.Errorf("archive of the call audio: %w", err)
+	}
+	logging.For(ctx, "retention").Info("Call archived", "archive", call.Record.Archive,
+		"files", len(call.Recordings)+len(call.Files), "duration", time.Since(start))
+	r.remove(ctx, call, "archived")
+	return nil
+}
+
+// keeps reports whether the audio of the call is kept
+func (r *Retention) keeps(record *cdr.Record) bool {
+	switch r.Keep {
+	case KeepNone:
+		return false
+	case KeepFlagged:
+		return record.Review != ""
+	default:
+		return true
+	}
+}
+
+func (r *Retention) archive(ctx context.Context, call Call) error {
+	prefix := archive.CallPrefix(call.Record.Start, call.Record.CallID)
+	for _, name := range call.Recordings {
+		audio, err := ariutil.DownloadRecording(ctx, name)
+		if err != nil {
+			return err
+		}
+		if err := r.Archive.Put(ctx, prefix+name+".wav", bytes.NewReader(audio), int64(len(audio))); err != nil {
+			return err
+		}
+	}
+	for _, name := range call.Files {
+		if err := r.putFile(ctx, prefix+name+".wav", filepath.Join(r.Dir, name+".wav")); err != nil {
+			return err
+		}
+	}
+	call.Record.Archive = prefix
+	data, err := json.MarshalIndent(call.Record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.Archive.Put(ctx, prefix+"record.json", bytes.NewReader(data), int64(len(data)))
+}
+
+func (r *Retention) putFile(ctx context.Context, key string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return r.Archive.Put(ctx, key, file, info.Size())
+}
+
+// remove deletes the audio of the call, counted under action
+func (r *Retention) remove(ctx context.Context, call Call, action string) {
+	for _, name := range call.Recordings {
+		r.deleteRecording(ctx, name, action)
+	}
+	for _, name := range call.Files {
+		r.deleteFile(ctx, name, action)
+	}
+}
+
+func (r *Retention) deleteRecording(ctx context.Context, name string, action string) {
+	if err := r.client.StoredRecording().Delete(ari.NewKey(ari.StoredRecordingKey, name)); err != nil {
+		logging.For(ctx, "retention").Warn("Cannot delete the recording", "recording", name, "err", err)
+		return
+	}
+	metrics.RetentionFiles.WithLabelValues(action).Inc()
+}
+
+func (r *Retention) deleteFile(ctx context.Context, name string, action string) {
+	err := os.Remove(filepath.Join(r.Dir, name+".wav"))
+	if err != nil && !os.IsNotExist(err) {
+		logging.For(ctx, "retention").Warn("Cannot delete the file", "file", name, "err", err)
+		return
+	}
+	if r.remover != nil {
+		if err := r.remover.Delete(ctx, name); err != nil {
+			logging.For(ctx, "retention").Debug("Cannot delete the copy next to Asterisk", "file", name, "err", err)
+		}
+	}
+	metrics.RetentionFiles.WithLabelValues(action).Inc()
+}
+
+// Run deletes the audio older than MaxAge at start and every hour, until ctx
+// is done
+func (r *Retention) Run(ctx context.Context) {
+	if r == nil || r.MaxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.Sweep(ctx); err != nil && ctx.Err() == nil {
+			logging.For(ctx, "retention").Error("Retention sweep failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes the archived files, the stored recordings and the generated
+// audio of the calls older than MaxAge
+func (r *Retention) Sweep(ctx context.Context) error {
+	cutoff := time.Now().Add(-r.MaxAge)
+	var errs []error
+	expired := 0
+
+	if r.Archive != nil {
+		objects, err := r.Archive.List(ctx, "")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("archive: %w", err))
+		}
+		for _, obj := range objects {
+			if !obj.Modified.Before(cutoff) {
+				continue
+			}
+			if err := r.Archive.Delete(ctx, obj.Key); err != nil {
+				errs = append(errs, fmt.Errorf("archive: %w", err))
+				continue
+			}
+			metrics.RetentionFiles.WithLabelValues("expired").Inc()
+			expired++
+		}
+	}
+
+	// Asterisk only gives the names of its recordings, they hold the time
+	keys, err := r.client.StoredRecording().List(nil)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("stored recordings: %w", err))
+	}
+	for _, key := range keys {
+		if t, ok := recordedAt(key.ID); ok && t.Before(cutoff) {
+			r.deleteRecording(ctx, key.ID, "expired")
+			expired++
+		}
+	}
+
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".wav")
+		if !ok || entry.IsDir() || !isCallAudio(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		r.deleteFile(ctx, name, "expired")
+		expired++
+	}
+
+	if expired > 0 {
+		logging.For(ctx, "retention").Info("Deleted the expired audio", "files", expired, "max_age", r.MaxAge)
+	}
+	return errors.Join(errs...)
+}
+
+func isCallAudio(name string) bool {
+	for _, prefix := range callAudio {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// audioTime matches the time in the name of the audio of a call, before the
+// suffix of an answer (_tts) or of one of its sentences (_tts_2)
+var audioTime = regexp.MustCompile(`_(\d+)(_tts(_\d+)?)?$`)
+
+// recordedAt returns the time in the name of the audio of a call
+func recordedAt(name string) (time.Time, bool) {
+	if !isCallAudio(name) {
+		return time.Time{}, false
+	}
+	m := audioTime.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+	v, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || v <= 0 {
+		return time.Time{}, false
+	}
+	// Seconds for the recordings, nanoseconds for the admin messages
+	if v > 1e12 {
+		return time.Unix(0, v), true
+	}
+	return time.Unix(v, 0), true
+}
@@ -0,0 +1,38 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"ari/internal/policy"
+)
+
+// Chain transcribes with the first of its providers which succeeds
+type Chain struct {
+	chain     *policy.Chain
+	providers []Transcriber
+}
+
+func (c *Chain) Transcribe(ctx context.Context, audio io.Reader) (transcript string, err error) {
+	// Every provider reads the audio from the start
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		return "", err
+	}
+	err = c.chain.Do(ctx, func(ctx context.Context, i int) error {
+		transcript, err = c.providers[i].Transcribe(ctx, bytes.NewReader(data))
+		return err
+	})
+	return transcript, err
+}
+
+// Ping succeeds when one of the providers is reachable
+func (c *Chain) Ping(ctx context.Context) error {
+	return c.chain.Ping(ctx, func(ctx context.Context, i int) error {
+		if p, ok := c.providers[i].(interface{ Ping(context.Context) error }); ok {
+			return p.Ping(ctx)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,65 @@
+package stt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Fake returns scripted transcripts, for offline and reproducible runs.
+// A recording whose SHA-256 is in ByAudio gets that transcript, otherwise the
+// next entry of Sequence is used (the last one repeats), then Default.
+type Fake struct {
+	ByAudio  map[string]string `json:"by_audio"` // hex SHA-256 of the audio -> transcript
+	Sequence []string          `json:"sequence"`
+	Default  string            `json:"default"`
+
+	mu   sync.Mutex
+	next int
+}
+
+// LoadFake reads the "stt" section of a fake script file. Without a path or a
+// section, the Fake always answers "hello".
+func LoadFake(path string) (*Fake, error) {
+	if path == "" {
+		return &Fake{Default: "hello"}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script struct {
+		STT *Fake `json:"stt"`
+	}
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, err
+	}
+	if script.STT == nil {
+		return &Fake{Default: "hello"}, nil
+	}
+	return script.STT, nil
+}
+
+func (f *Fake) Transcribe(ctx context.Context, audio io.Reader) (string, error) {
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	if t, ok := f.ByAudio[hex.EncodeToString(sum[:])]; ok {
+		return t, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.Sequence) == 0 {
+		return f.Default, nil
+	}
+	t := f.Sequence[min(f.next, len(f.Sequence)-1)]
+	f.next++
+	return t, nil
+}
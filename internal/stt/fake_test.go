@@ -0,0 +1,81 @@
+package stt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func transcribe(t *testing.T, f *Fake, audio string) string {
+	t.Helper()
+	text, err := f.Transcribe(context.Background(), strings.NewReader(audio))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+func TestFakeByAudio(t *testing.T) {
+	sum := sha256.Sum256([]byte("question"))
+	f := &Fake{
+		ByAudio:  map[string]string{hex.EncodeToString(sum[:]): "opening hours"},
+		Sequence: []string{"first"},
+	}
+	if got := transcribe(t, f, "question"); got != "opening hours" {
+		t.Errorf("known audio: got %q", got)
+	}
+	// The known audio does not use the sequence up
+	if got := transcribe(t, f, "other"); got != "first" {
+		t.Errorf("other audio: got %q", got)
+	}
+}
+
+func TestFakeSequence(t *testing.T) {
+	f := &Fake{Sequence: []string{"one", "two"}, Default: "unused"}
+	for i, want := range []string{"one", "two", "two"} {
+		if got := transcribe(t, f, "audio"); got != want {
+			t.Errorf("transcript %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestFakeDefault(t *testing.T) {
+	if got := transcribe(t, &Fake{Default: "hi"}, "audio"); got != "hi" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLoadFake(t *testing.T) {
+	f, err := LoadFake("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := transcribe(t, f, "audio"); got != "hello" {
+		t.Errorf("without a script: got %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(path, []byte(`{"stt": {"sequence": ["a", "b"], "default": "c"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = LoadFake(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := transcribe(t, f, "audio"); got != "a" {
+		t.Errorf("with a script: got %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"llm": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = LoadFake(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := transcribe(t, f, "audio"); got != "hello" {
+		t.Errorf("without an stt section: got %q", got)
+	}
+}
@@ -0,0 +1,39 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"ari/internal/policy"
+)
+
+// WithPolicy applies p to every transcription of t
+func WithPolicy(t Transcriber, p *policy.Policy) Transcriber {
+	return guarded{t, p}
+}
+
+type guarded struct {
+	Transcriber
+	policy *policy.Policy
+}
+
+func (g guarded) Transcribe(ctx context.Context, audio io.Reader) (transcript string, err error) {
+	// Every attempt reads the audio from the start
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		return "", err
+	}
+	err = g.policy.Do(ctx, func(ctx context.Context) error {
+		transcript, err = g.Transcriber.Transcribe(ctx, bytes.NewReader(data))
+		return err
+	})
+	return transcript, err
+}
+
+func (g guarded) Ping(ctx context.Context) error {
+	if p, ok := g.Transcriber.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package stt
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"os"
 
 	apiInterfaces "github.com/deepgram/deepgram-go-sdk/pkg/api/prerecorded/v1/interfaces"
 	interfaces "github.com/deepgram/deepgram-go-sdk/v3/pkg/client/interfaces"
@@ -13,6 +17,9 @@ import (
 func DgSendPreRecorded(ctx context.Context, src io.Reader, resBody *apiInterfaces.PreRecordedResponse) error {
 	//Deepgram-client
 	c := client.NewRESTWithDefaults()
+	if c == nil {
+		return errors.New("cannot create the Deepgram client, is DEEPGRAM_API_KEY set?")
+	}
 
 	transcriptOptions := &interfaces.PreRecordedTranscriptionOptions{
 		Language:  "en-US",
@@ -25,3 +32,21 @@ func DgSendPreRecorded(ctx context.Context, src io.Reader, resBody *apiInterface
 	}
 	return nil
 }
+
+// PingDeepgram checks the Deepgram API is reachable and accepts DEEPGRAM_API_KEY
+func PingDeepgram(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.deepgram.com/v1/projects", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+os.Getenv("DEEPGRAM_API_KEY"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("deepgram returned %s", resp.Status)
+	}
+	return nil
+}
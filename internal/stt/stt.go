@@ -0,0 +1,83 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"ari/internal/logging"
+	"ari/internal/metrics"
+	"ari/internal/policy"
+
+	apiInterfaces "github.com/deepgram/deepgram-go-sdk/pkg/api/prerecorded/v1/interfaces"
+	interfaces "github.com/deepgram/deepgram-go-sdk/v3/pkg/client/interfaces"
+)
+
+// Transcriber turns a caller recording into text
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio io.Reader) (string, error)
+}
+
+// New returns the providers of STT_BACKEND, a comma separated list tried in
+// order ("deepgram" by default, "vosk" or "fake"), each with its own policy
+func New() (*Chain, error) {
+	names := policy.ParseProviders(os.Getenv("STT_BACKEND"), "deepgram")
+	chain, err := policy.ChainFromEnv(metrics.STT, names)
+	if err != nil {
+		return nil, err
+	}
+	providers := make([]Transcriber, len(names))
+	for i, name := range names {
+		t, err := newProvider(name)
+		if err != nil {
+			return nil, err
+		}
+		p, err := policy.FromEnv(metrics.STT, name)
+		if err != nil {
+			return nil, err
+		}
+		providers[i] = WithPolicy(t, p)
+	}
+	return &Chain{chain: chain, providers: providers}, nil
+}
+
+func newProvider(name string) (Transcriber, error) {
+	switch name {
+	case "deepgram":
+		return Deepgram{}, nil
+	case "vosk":
+		return NewVosk(), nil
+	case "fake":
+		return LoadFake(os.Getenv("FAKE_SCRIPT"))
+	default:
+		return nil, fmt.Errorf("unknown STT_BACKEND provider %q", name)
+	}
+}
+
+// Deepgram transcribes with the Deepgram pre-recorded API
+type Deepgram struct{}
+
+func (Deepgram) Transcribe(ctx context.Context, audio io.Reader) (string, error) {
+	var resBody apiInterfaces.PreRecordedResponse
+	if err := DgSendPreRecorded(ctx, audio, &resBody); err != nil {
+		var status *interfaces.StatusError
+		if errors.As(err, &status) && status.Resp != nil && policy.PermanentStatus(status.Resp.StatusCode) {
+			return "", policy.Permanent(err)
+		}
+		return "", err
+	}
+	logging.For(ctx, "stt").Debug("Deepgram transcription", "request_id", resBody.RequestID)
+	if resBody.Results == nil ||
+		len(resBody.Results.Channels) == 0 ||
+		len(resBody.Results.Channels[0].Alternatives) == 0 {
+		// Silent recording, nothing to retry
+		return "", policy.Permanent(errors.New("deepgram returned no transcription"))
+	}
+	return resBody.Results.Channels[0].Alternatives[0].Transcript, nil
+}
+
+func (Deepgram) Ping(ctx context.Context) error {
+	return PingDeepgram(ctx)
+}
@@ -0,0 +1,124 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"ari/internal/logging"
+	"ari/internal/policy"
+
+	"github.com/gorilla/websocket"
+)
+
+// Vosk transcribes with a self-hosted vosk-server, over its WebSocket API
+type Vosk struct {
+	URL string // ex : ws://vosk:2700
+}
+
+// NewVosk returns the Vosk transcriber of VOSK_URL (ws://localhost:2700 by default)
+func NewVosk() Vosk {
+	url := os.Getenv("VOSK_URL")
+	if url == "" {
+		url = "ws://localhost:2700"
+	}
+	return Vosk{URL: url}
+}
+
+// voskChunk is the size of the audio messages, 0.25s at 8000 Hz
+const voskChunk = 4000
+
+func (v Vosk) Transcribe(ctx context.Context, audio io.Reader) (string, error) {
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		return "", err
+	}
+	pcm, sampleRate, err := wavPCM(data)
+	if err != nil {
+		return "", policy.Permanent(err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, v.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// Unblock the reads when the call goes away
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	config := map[string]any{"config": map[string]any{"sample_rate": sampleRate}}
+	if err := conn.WriteJSON(config); err != nil {
+		return "", err
+	}
+	// The server answers every audio message with a partial result
+	for len(pcm) > 0 {
+		n := min(voskChunk, len(pcm))
+		if err := conn.WriteMessage(websocket.BinaryMessage, pcm[:n]); err != nil {
+			return "", err
+		}
+		pcm = pcm[n:]
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return "", err
+		}
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"eof" : 1}`)); err != nil {
+		return "", err
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := conn.ReadJSON(&result); err != nil {
+		return "", err
+	}
+	logging.For(ctx, "stt").Debug("Vosk transcription", "chars", len(result.Text))
+	return result.Text, nil
+}
+
+// Ping checks the server accepts connections
+func (v Vosk) Ping(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, v.URL, nil)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// wavPCM returns the samples and sample rate of a 16-bit mono PCM WAV file,
+// like the Asterisk recordings
+func wavPCM(data []byte) ([]byte, int, error) {
+	if len(data) < 12 || !bytes.Equal(data[0:4], []byte("RIFF")) || !bytes.Equal(data[8:12], []byte("WAVE")) {
+		return nil, 0, errors.New("not a WAV file")
+	}
+	sampleRate := 0
+	for chunks := data[12:]; len(chunks) >= 8; {
+		id, size := string(chunks[0:4]), int(binary.LittleEndian.Uint32(chunks[4:8]))
+		body := chunks[8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("invalid WAV fmt chunk")
+			}
+			format, channels, bits := binary.LittleEndian.Uint16(body[0:2]), binary.LittleEndian.Uint16(body[2:4]), binary.LittleEndian.Uint16(body[14:16])
+			if format != 1 || channels != 1 || bits != 16 {
+				return nil, 0, fmt.Errorf("unsupported WAV format %d, %d channels, %d bits", format, channels, bits)
+			}
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+		case "data":
+			if sampleRate == 0 {
+				return nil, 0, errors.New("WAV data before the fmt chunk")
+			}
+			return body[:size], sampleRate, nil
+		}
+		// Chunks are padded to an even size
+		chunks = body[min(size+size%2, len(body)):]
+	}
+	return nil, 0, errors.New("WAV file without data")
+}
@@ -0,0 +1,124 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"ari/internal/ai"
+)
+
+// Hours are the opening hours of the business, by day of the week
+type Hours struct {
+	Spec     string // as configured, ex : "mon-fri 09:00-12:00,14:00-18:00; sat 10:00-12:00"
+	Location *time.Location
+	days     [7][]span // by time.Weekday
+}
+
+// span is a time range of a day, in minutes since midnight
+type span struct {
+	from, to int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseHours reads opening hours like "mon-fri 09:00-12:00,14:00-18:00; sat
+// 10:00-12:00" in the time zone tz (the local one when empty)
+func ParseHours(spec string, tz string) (*Hours, error) {
+	h := &Hours{Spec: spec, Location: time.Local}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OPENING_HOURS_TZ: %w", err)
+		}
+		h.Location = loc
+	}
+	for _, item := range strings.Split(spec, ";") {
+		days, ranges, ok := strings.Cut(strings.TrimSpace(item), " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid opening hours %q, expected days and time ranges, ex : mon-fri 09:00-17:00", item)
+		}
+		weekdays, err := parseDays(days)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range strings.Split(strings.TrimSpace(ranges), ",") {
+			s, err := parseSpan(strings.TrimSpace(r))
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range weekdays {
+				h.days[d] = append(h.days[d], s)
+			}
+		}
+	}
+	return h, nil
+}
+
+// parseDays reads "mon-fri", "sat" or "mon,wed"
+func parseDays(days string) ([]time.Weekday, error) {
+	var result []time.Weekday
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := weekdays[first]
+		to, ok2 := weekdays[last]
+		if !isRange {
+			to, ok2 = from, true
+		}
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid days %q in the opening hours", part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			result = append(result, d)
+			if d == to {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// parseSpan reads "09:00-17:00"
+func parseSpan(r string) (span, error) {
+	from, to, ok := strings.Cut(r, "-")
+	start, err1 := time.Parse("15:04", from)
+	end, err2 := time.Parse("15:04", to)
+	if !ok || err1 != nil || err2 != nil || !end.After(start) {
+		return span{}, fmt.Errorf("invalid time range %q in the opening hours, ex : 09:00-17:00", r)
+	}
+	return span{start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute()}, nil
+}
+
+// OpenAt tells whether the business is open at t
+func (h *Hours) OpenAt(t time.Time) bool {
+	t = t.In(h.Location)
+	minute := t.Hour()*60 + t.Minute()
+	for _, s := range h.days[t.Weekday()] {
+		if minute >= s.from && minute < s.to {
+			return true
+		}
+	}
+	return false
+}
+
+// Tool returns the opening_hours tool, telling the hours and whether the
+// business is open now
+func (h *Hours) Tool() ai.Tool {
+	return ai.Tool{
+		Name:        "opening_hours",
+		Description: "Get the opening hours of the business, the current day and time, and whether it is open now.",
+		Func: func(ctx context.Context, args json.RawMessage) (any, error) {
+			now := time.Now().In(h.Location)
+			return map[string]any{
+				"opening_hours": h.Spec,
+				"now":           now.Format("Monday 15:04"),
+				"open_now":      h.OpenAt(now),
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,168 @@
+// Package tools holds the business actions the LLM can take during a call:
+// checking the opening hours, looking up the status of an order and booking
+// an appointment. The order and appointment tools are forwarded to the
+// business API of BUSINESS_API_URL.
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"ari/internal/ai"
+	"ari/internal/redact"
+)
+
+// FromEnv returns the registry of the tools enabled by the environment:
+// opening_hours with OPENING_HOURS, order_status and book_appointment with
+// BUSINESS_API_URL. TOOLS=off disables the tools, the registry is nil then.
+func FromEnv() (*ai.Registry, error) {
+	r := ai.NewRegistry()
+	switch mode := os.Getenv("TOOLS"); mode {
+	case "", "on":
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown TOOLS %q, expected on or off", mode)
+	}
+
+	if spec := os.Getenv("OPENING_HOURS"); spec != "" {
+		hours, err := ParseHours(spec, os.Getenv("OPENING_HOURS_TZ"))
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Register(hours.Tool()); err != nil {
+			return nil, err
+		}
+	}
+	if url := os.Getenv("BUSINESS_API_URL"); url != "" {
+		api := &API{URL: strings.TrimSuffix(url, "/"), Token: os.Getenv("BUSINESS_API_TOKEN")}
+		for _, t := range api.Tools() {
+			if err := r.Register(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// API forwards the tool calls to a business API: POST <URL>/<tool> with the
+// arguments and the call, answered with the JSON result
+type API struct {
+	URL   string // ex : https://crm.example.com/ivr
+	Token string // bearer token, optional
+}
+
+// Tools returns the tools served by the API
+func (a *API) Tools() []ai.Tool {
+	return []ai.Tool{
+		{
+			Name:        "order_status",
+			Description: "Look up the status of an order of the caller: where it is and when it is delivered.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"order_id": map[string]any{"type": "string", "description": "order number, as said by the caller"},
+				},
+				"required": []string{"order_id"},
+			},
+			Func: a.forward("order_status"),
+		},
+		{
+			Name:        "book_appointment",
+			Description: "Book an appointment for the caller. Ask for the day and the time first, and confirm the booking with the result.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"date":   map[string]any{"type": "string", "description": "day of the appointment, YYYY-MM-DD"},
+					"time":   map[string]any{"type": "string", "description": "time of the appointment, HH:MM, 24 hours"},
+					"name":   map[string]any{"type": "string", "description": "name of the caller"},
+					"reason": map[string]any{"type": "string", "description": "what the appointment is for"},
+				},
+				"required": []string{"date", "time"},
+			},
+			Func: a.forward("book_appointment"),
+		},
+	}
+}
+
+// forward returns the tool function posting to <URL>/<name>. The
+// placeholders of the personal data in the arguments are replaced with their
+// values, the API is trusted.
+func (a *API) forward(name string) ai.ToolFunc {
+	return func(ctx context.Context, args json.RawMessage) (any, error) {
+		args, err := restoreArgs(redact.ValuesFrom(ctx), args)
+		if err != nil {
+			return nil, err
+		}
+		body, err := json.Marshal(map[string]any{"args": args, "call": ai.CallFrom(ctx)})
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "POST", a.URL+"/"+name, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if a.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+a.Token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("business API returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+		var result any
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("business API: invalid response: %w", err)
+		}
+		return result, nil
+	}
+}
+
+// restoreArgs puts the original values back in place of the placeholders of
+// the string values of the JSON arguments: a value may hold quotes or
+// backslashes to escape
+func restoreArgs(v *redact.Values, args json.RawMessage) (json.RawMessage, error) {
+	if v == nil || len(args) == 0 {
+		return args, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid tool arguments: %w", err)
+	}
+	return json.Marshal(restoreValue(v, value))
+}
+
+func restoreValue(v *redact.Values, value any) any {
+	switch x := value.(type) {
+	case string:
+		return v.Restore(x)
+	case []any:
+		for i := range x {
+			x[i] = restoreValue(v, x[i])
+		}
+	case map[string]any:
+		for k := range x {
+			x[k] = restoreValue(v, x[k])
+		}
+	}
+	return value
+}
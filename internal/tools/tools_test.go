@@ -0,0 +1,33 @@
+package tools
+
+import (
+	"encoding/json"
+	"testing"
+
+	"ari/internal/redact"
+)
+
+func TestRestoreArgs(t *testing.T) {
+	r := &redact.Redactor{Kinds: []redact.Kind{redact.Email}}
+	v := redact.NewValues()
+	if got := r.Placeholders("write to jo.doe@example.com", v); got != "write to [EMAIL_1]" {
+		t.Fatalf("placeholders: got %q", got)
+	}
+
+	args := json.RawMessage(`{"to": "[EMAIL_1]", "cc": ["[EMAIL_1]", "[EMAIL_2]"], "order": 12345678901234567890, "note": "say \"hi\""}`)
+	got, err := restoreArgs(v, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"cc":["jo.doe@example.com","[EMAIL_2]"],"note":"say \"hi\"","order":12345678901234567890,"to":"jo.doe@example.com"}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if _, err := restoreArgs(v, json.RawMessage(`{"to": `)); err == nil {
+		t.Error("invalid arguments: no error")
+	}
+	if got, err := restoreArgs(nil, args); err != nil || string(got) != string(args) {
+		t.Errorf("without values: got %s, %v", got, err)
+	}
+}
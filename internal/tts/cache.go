@@ -0,0 +1,269 @@
+package tts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ari/internal/logging"
+	"ari/internal/metrics"
+
+	"github.com/charmbracelet/log"
+)
+
+// Cache keeps the synthesized files on disk, named by the hash of what makes
+// the audio: provider, voice, encoding, sample rate and normalized text. The
+// least recently used files are evicted past MaxSize bytes or MaxAge.
+type Cache struct {
+	Dir     string
+	MaxSize int64         // bytes, unlimited when 0
+	MaxAge  time.Duration // since the last use, unlimited when 0
+
+	mu        sync.Mutex
+	lastEvict time.Time
+}
+
+// evictInterval is the minimum time between two scans of the cache directory
+const evictInterval = time.Minute
+
+// CacheFromEnv returns the cache of TTS_CACHE_DIR (the cache directory of
+// TTS_DIR by default), bounded by TTS_CACHE_MAX_SIZE in MB (500 by default)
+// and TTS_CACHE_MAX_AGE (720h by default). It is nil when TTS_CACHE=off.
+func CacheFromEnv() (*Cache, error) {
+	if os.Getenv("TTS_CACHE") == "off" {
+		return nil, nil
+	}
+	c := &Cache{Dir: os.Getenv("TTS_CACHE_DIR"), MaxSize: 500 << 20, MaxAge: 30 * 24 * time.Hour}
+	if c.Dir == "" {
+		c.Dir = filepath.Join(Dir(), "cache")
+	}
+	if v := os.Getenv("TTS_CACHE_MAX_SIZE"); v != "" {
+		mb, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("invalid TTS_CACHE_MAX_SIZE %q", v)
+		}
+		c.MaxSize = mb << 20
+	}
+	if v := os.Getenv("TTS_CACHE_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid TTS_CACHE_MAX_AGE %q", v)
+		}
+		c.MaxAge = d
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("TTS cache: %w", err)
+	}
+	c.lastEvict = time.Now()
+	c.evict()
+	return c, nil
+}
+
+// Wrap caches the files of s, speaking with the voice of a provider. The
+// cache is bypassed when c is nil.
+func (c *Cache) Wrap(provider, voice string, s Synthesizer) Synthesizer {
+	if c == nil {
+		return s
+	}
+	return cached{s, c, provider + "\x00" + voice}
+}
+
+type privateKey struct{}
+
+// Private marks the texts synthesized with ctx as holding personal data. They
+// bypass the cache: their only file is the one of the call, which the
+// retention archives, encrypts or deletes.
+func Private(ctx context.Context) context.Context {
+	return context.WithValue(ctx, privateKey{}, true)
+}
+
+func isPrivate(ctx context.Context) bool {
+	private, _ := ctx.Value(privateKey{}).(bool)
+	return private
+}
+
+type cached struct {
+	Synthesizer
+	cache *Cache
+	voice string // provider and voice
+}
+
+// SynthesizeFile links the cached file to filePath, or synthesizes it and
+// keeps a copy. The private texts bypass the cache.
+func (c cached) SynthesizeFile(ctx context.Context, text string, filePath string) error {
+	if isPrivate(ctx) {
+		return c.Synthesizer.SynthesizeFile(ctx, text, filePath)
+	}
+	path := c.cache.path(c.voice, text)
+	if err := place(path, filePath); err == nil {
+		// Last use, for the eviction
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		metrics.TTSCache.WithLabelValues("hit").Inc()
+		logging.For(ctx, "tts").Debug("TTS cache hit", "file", filePath, "cached", path)
+		return nil
+	}
+	metrics.TTSCache.WithLabelValues("miss").Inc()
+
+	if err := c.Synthesizer.SynthesizeFile(ctx, text, filePath); err != nil {
+		return err
+	}
+	if err := c.cache.store(filePath, path); err != nil {
+		logging.For(ctx, "tts").Warn("Cannot cache the TTS file", "file", filePath, "err", err)
+	}
+	return nil
+}
+
+func (c cached) Ping(ctx context.Context) error {
+	if p, ok := c.Synthesizer.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// path returns the file caching text spoken with voice, as linear16 WAV at SampleRate
+func (c *Cache) path(voice, text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	sum := sha256.Sum256([]byte(voice + "\x00linear16\x00" + strconv.Itoa(SampleRate) + "\x00" + text))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".wav")
+}
+
+// store copies the synthesized file into the cache, atomically so a
+// concurrent reader never sees a partial file
+func (c *Cache) store(src, path string) error {
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	in, err := os.Open(src)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	in.Close()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastEvict) >= evictInterval {
+		c.lastEvict = time.Now()
+		go c.evict()
+	}
+	return nil
+}
+
+// place makes dst a copy of the cached file src, a hard link when possible
+func place(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// evict removes the files unused for MaxAge, then the least recently used
+// ones until the cache fits in MaxSize
+func (c *Cache) evict() {
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var entries []entry
+	var total int64
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".wav") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		log.Warn("Cannot scan the TTS cache", "dir", c.Dir, "err", err)
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	removed := 0
+	for _, e := range entries {
+		expired := c.MaxAge > 0 && time.Since(e.used) > c.MaxAge
+		if !expired && (c.MaxSize == 0 || total <= c.MaxSize) {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn("Cannot evict from the TTS cache", "file", e.path, "err", err)
+			continue
+		}
+		total -= e.size
+		removed++
+	}
+	metrics.TTSCacheBytes.Set(float64(total))
+	if removed > 0 {
+		log.Info("TTS cache evicted", "files", removed, "bytes", total)
+	}
+}
+
+// voiceOf identifies the voice of a provider, part of the cache key
+func voiceOf(s Synthesizer) string {
+	switch s := s.(type) {
+	case Deepgram:
+		return DeepgramVoice
+	case Piper:
+		return s.Voice
+	case *Fake:
+		return fmt.Sprintf("%+v", *s)
+	default:
+		return fmt.Sprintf("%T", s)
+	}
+}
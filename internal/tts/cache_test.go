@@ -0,0 +1,40 @@
+package tts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachePrivate(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cache{Dir: filepath.Join(dir, "cache")}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := c.Wrap("fake", "", &Fake{WordDuration: 100 * time.Millisecond})
+	cachedFiles := func() int {
+		entries, err := os.ReadDir(c.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	ctx := context.Background()
+	if err := s.SynthesizeFile(ctx, "We are open from nine to five.", filepath.Join(dir, "answer.wav")); err != nil {
+		t.Fatal(err)
+	}
+	if n := cachedFiles(); n != 1 {
+		t.Errorf("answer: %d cached files, want 1", n)
+	}
+
+	if err := s.SynthesizeFile(Private(ctx), "Your card ends with 1234.", filepath.Join(dir, "private.wav")); err != nil {
+		t.Fatal(err)
+	}
+	if n := cachedFiles(); n != 1 {
+		t.Errorf("private text: %d cached files, want still 1", n)
+	}
+}
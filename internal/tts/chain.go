@@ -0,0 +1,30 @@
+package tts
+
+import (
+	"context"
+
+	"ari/internal/policy"
+)
+
+// Chain synthesizes with the first of its providers which succeeds
+type Chain struct {
+	chain     *policy.Chain
+	providers []Synthesizer
+}
+
+// SynthesizeFile lets each provider in turn overwrite filePath, until one succeeds
+func (c *Chain) SynthesizeFile(ctx context.Context, text string, filePath string) error {
+	return c.chain.Do(ctx, func(ctx context.Context, i int) error {
+		return c.providers[i].SynthesizeFile(ctx, text, filePath)
+	})
+}
+
+// Ping succeeds when one of the providers is reachable
+func (c *Chain) Ping(ctx context.Context) error {
+	return c.chain.Ping(ctx, func(ctx context.Context, i int) error {
+		if p, ok := c.providers[i].(interface{ Ping(context.Context) error }); ok {
+			return p.Ping(ctx)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,89 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Fake writes a tone (or silence) WAV whose length only depends on the number
+// of words of the text, for offline and reproducible runs.
+type Fake struct {
+	WordDuration time.Duration
+	MinDuration  time.Duration
+	Silence      bool
+	Frequency    float64 // Hz, 440 by default
+}
+
+// LoadFake reads the "tts" section of a fake script file, ex :
+//
+//	{"tts": {"word_duration": "300ms", "min_duration": "500ms", "silence": false}}
+func LoadFake(path string) (*Fake, error) {
+	f := &Fake{WordDuration: 300 * time.Millisecond, MinDuration: 500 * time.Millisecond}
+	if path == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script struct {
+		TTS *struct {
+			WordDuration string  `json:"word_duration"`
+			MinDuration  string  `json:"min_duration"`
+			Silence      bool    `json:"silence"`
+			Frequency    float64 `json:"frequency"`
+		} `json:"tts"`
+	}
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, err
+	}
+	if script.TTS == nil {
+		return f, nil
+	}
+	if script.TTS.WordDuration != "" {
+		if f.WordDuration, err = time.ParseDuration(script.TTS.WordDuration); err != nil {
+			return nil, err
+		}
+	}
+	if script.TTS.MinDuration != "" {
+		if f.MinDuration, err = time.ParseDuration(script.TTS.MinDuration); err != nil {
+			return nil, err
+		}
+	}
+	f.Silence = script.TTS.Silence
+	f.Frequency = script.TTS.Frequency
+	return f, nil
+}
+
+// Duration returns the length of the audio generated for the text
+func (f *Fake) Duration(text string) time.Duration {
+	return max(time.Duration(len(strings.Fields(text)))*f.WordDuration, f.MinDuration)
+}
+
+func (f *Fake) SynthesizeFile(ctx context.Context, text string, filePath string) error {
+	n := int(f.Duration(text).Seconds() * SampleRate)
+	samples := make([]int16, n)
+	if !f.Silence {
+		freq := f.Frequency
+		if freq == 0 {
+			freq = 440
+		}
+		for i := range samples {
+			samples[i] = int16(8000 * math.Sin(2*math.Pi*freq*float64(i)/SampleRate))
+		}
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := WriteWAV(file, samples, SampleRate); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,80 @@
+package tts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFakeDuration(t *testing.T) {
+	f := &Fake{WordDuration: 100 * time.Millisecond, MinDuration: 500 * time.Millisecond}
+	for text, want := range map[string]time.Duration{
+		"":                          500 * time.Millisecond,
+		"Hello":                     500 * time.Millisecond,
+		"We are open from 9 to 5 .": 800 * time.Millisecond,
+	} {
+		if got := f.Duration(text); got != want {
+			t.Errorf("%q: got %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestFakeSynthesizeFile(t *testing.T) {
+	for _, silence := range []bool{false, true} {
+		f := &Fake{WordDuration: 250 * time.Millisecond, Silence: silence}
+		path := filepath.Join(t.TempDir(), "answer.wav")
+		if err := f.SynthesizeFile(context.Background(), "one two three four", path); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples, rate, err := ReadWAV(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate != SampleRate || len(samples) != SampleRate {
+			t.Errorf("silence %v: got %d samples at %d Hz, want a second at %d Hz", silence, len(samples), rate, SampleRate)
+		}
+		loud := false
+		for _, s := range samples {
+			loud = loud || s != 0
+		}
+		if loud == silence {
+			t.Errorf("silence %v: the audio is silent: %v", silence, !loud)
+		}
+	}
+}
+
+func TestLoadFake(t *testing.T) {
+	f, err := LoadFake("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.WordDuration != 300*time.Millisecond || f.MinDuration != 500*time.Millisecond {
+		t.Errorf("without a script: got %+v", f)
+	}
+
+	path := filepath.Join(t.TempDir(), "script.json")
+	script := `{"tts": {"word_duration": "100ms", "silence": true, "frequency": 880}}`
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = LoadFake(path); err != nil {
+		t.Fatal(err)
+	}
+	want := Fake{WordDuration: 100 * time.Millisecond, MinDuration: 500 * time.Millisecond, Silence: true, Frequency: 880}
+	if *f != want {
+		t.Errorf("with a script: got %+v, want %+v", *f, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"tts": {"min_duration": "long"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFake(path); err == nil {
+		t.Error("invalid min duration: no error")
+	}
+}
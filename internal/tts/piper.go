@@ -0,0 +1,94 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"ari/internal/policy"
+)
+
+// Piper synthesizes with a self-hosted Piper HTTP server
+// (python3 -m piper.http_server), resampling its voice to 8000 Hz
+type Piper struct {
+	URL   string // ex : http://piper:5000
+	Voice string // the default voice of the server when empty
+}
+
+// NewPiper returns the Piper synthesizer of PIPER_URL (http://localhost:5000 by
+// default) speaking with PIPER_VOICE
+func NewPiper() Piper {
+	url := os.Getenv("PIPER_URL")
+	if url == "" {
+		url = "http://localhost:5000"
+	}
+	return Piper{URL: strings.TrimSuffix(url, "/"), Voice: os.Getenv("PIPER_VOICE")}
+}
+
+func (p Piper) SynthesizeFile(ctx context.Context, text string, filePath string) error {
+	request := map[string]string{"text": text}
+	if p.Voice != "" {
+		request["voice"] = p.Voice
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.URL+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("piper returned %s", resp.Status)
+		if policy.PermanentStatus(resp.StatusCode) {
+			return policy.Permanent(err)
+		}
+		return err
+	}
+
+	samples, rate, err := ReadWAV(data)
+	if err != nil {
+		return fmt.Errorf("piper: %w", err)
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := WriteWAV(file, Resample(samples, rate, SampleRate), SampleRate); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Ping checks the server answers
+func (p Piper) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.URL+"/voices", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("piper returned %s", resp.Status)
+	}
+	return nil
+}
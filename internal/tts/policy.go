@@ -0,0 +1,30 @@
+package tts
+
+import (
+	"context"
+
+	"ari/internal/policy"
+)
+
+// WithPolicy applies p to every synthesis of s
+func WithPolicy(s Synthesizer, p *policy.Policy) Synthesizer {
+	return guarded{s, p}
+}
+
+type guarded struct {
+	Synthesizer
+	policy *policy.Policy
+}
+
+func (g guarded) SynthesizeFile(ctx context.Context, text string, filePath string) error {
+	return g.policy.Do(ctx, func(ctx context.Context) error {
+		return g.Synthesizer.SynthesizeFile(ctx, text, filePath)
+	})
+}
+
+func (g guarded) Ping(ctx context.Context) error {
+	if p, ok := g.Synthesizer.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
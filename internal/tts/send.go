@@ -5,7 +5,8 @@ import (
 	// "io"
 	// "os"
 
-	"github.com/charmbracelet/log"
+	"ari/internal/logging"
+
 	apiClient "github.com/deepgram/deepgram-go-sdk/pkg/api/speak/v1/rest"
 	client "github.com/deepgram/deepgram-go-sdk/pkg/client/speak/v1/rest"
 
@@ -14,9 +15,12 @@ import (
 	interfaces "github.com/deepgram/deepgram-go-sdk/pkg/client/interfaces/v1"
 )
 
+// DeepgramVoice is the Aura voice of the answers
+const DeepgramVoice = "aura-2-thalia-en"
+
 func GetDgRawTTS(ctx context.Context, text string, raw *interfaces.RawResponse) (*apiSpeakResponseInterfaces.SpeakResponse, error) {
 	speakOptions := &interfaces.SpeakOptions{
-		Model:      "aura-2-thalia-en",
+		Model:      DeepgramVoice,
 		Encoding:   "linear16",
 		Container:  "wav",
 		SampleRate: 8000,
@@ -25,7 +29,7 @@ func GetDgRawTTS(ctx context.Context, text string, raw *interfaces.RawResponse)
 	apiCl := apiClient.New(cl)
 	res, err := apiCl.ToStream(ctx, text, speakOptions, raw)
 	if err != nil {
-		log.Error("Error getting TTS from Deepgram:", "error", err)
+		logging.For(ctx, "tts").Error("Error getting TTS from Deepgram", "err", err)
 		return nil, err
 	}
 
@@ -35,7 +39,7 @@ func GetDgRawTTS(ctx context.Context, text string, raw *interfaces.RawResponse)
 
 func GetDgFileTTS(ctx context.Context, text string, filePath string) (*apiSpeakResponseInterfaces.SpeakResponse, error) {
 	speakOptions := &interfaces.SpeakOptions{
-		Model:      "aura-2-thalia-en",
+		Model:      DeepgramVoice,
 		Encoding:   "linear16",
 		Container:  "wav",
 		SampleRate: 8000,
@@ -45,9 +49,9 @@ func GetDgFileTTS(ctx context.Context, text string, filePath string) (*apiSpeakR
 	apiCl := apiClient.New(cl)
 	res, err := apiCl.ToSave(ctx, filePath, text, speakOptions)
 	if err != nil {
-		log.Error("Error getting TTS from Deepgram:", "error", err)
+		logging.For(ctx, "tts").Error("Error getting TTS from Deepgram", "err", err)
 		return nil, err
 	}
-	log.Info("File created successfully")
+	logging.For(ctx, "tts").Debug("File created successfully", "file", filePath)
 	return res, nil
 }
@@ -0,0 +1,87 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"ari/internal/metrics"
+	"ari/internal/policy"
+	"ari/internal/stt"
+
+	interfaces "github.com/deepgram/deepgram-go-sdk/pkg/client/interfaces/v1"
+)
+
+// Synthesizer turns text into a WAV file (linear16, 8000 Hz, mono) playable by Asterisk
+type Synthesizer interface {
+	SynthesizeFile(ctx context.Context, text string, filePath string) error
+}
+
+// Dir is the directory shared with Asterisk where answers are written (TTS_DIR)
+func Dir() string {
+	if dir := os.Getenv("TTS_DIR"); dir != "" {
+		return dir
+	}
+	return "/mnt/tts"
+}
+
+// New returns the providers of TTS_BACKEND, a comma separated list tried in
+// order ("deepgram" by default, "piper" or "fake"), each with its own policy
+// and cache
+func New() (*Chain, error) {
+	names := policy.ParseProviders(os.Getenv("TTS_BACKEND"), "deepgram")
+	chain, err := policy.ChainFromEnv(metrics.TTS, names)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := CacheFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	providers := make([]Synthesizer, len(names))
+	for i, name := range names {
+		s, err := newProvider(name)
+		if err != nil {
+			return nil, err
+		}
+		p, err := policy.FromEnv(metrics.TTS, name)
+		if err != nil {
+			return nil, err
+		}
+		// A cached answer plays even while its provider is down
+		providers[i] = cache.Wrap(name, voiceOf(s), WithPolicy(s, p))
+	}
+	return &Chain{chain: chain, providers: providers}, nil
+}
+
+func newProvider(name string) (Synthesizer, error) {
+	switch name {
+	case "deepgram":
+		return Deepgram{}, nil
+	case "piper":
+		return NewPiper(), nil
+	case "fake":
+		return LoadFake(os.Getenv("FAKE_SCRIPT"))
+	default:
+		return nil, fmt.Errorf("unknown TTS_BACKEND provider %q", name)
+	}
+}
+
+// Deepgram synthesizes with Deepgram Aura
+type Deepgram struct{}
+
+func (Deepgram) SynthesizeFile(ctx context.Context, text string, filePath string) error {
+	_, err := GetDgFileTTS(ctx, text, filePath)
+	var status *interfaces.StatusError
+	if errors.As(err, &status) && status.Resp != nil && policy.PermanentStatus(status.Resp.StatusCode) {
+		return policy.Permanent(err)
+	}
+	return err
+}
+
+// Ping checks the Deepgram API is reachable with the configured key, the same
+// account serves STT and TTS
+func (Deepgram) Ping(ctx context.Context) error {
+	return stt.PingDeepgram(ctx)
+}
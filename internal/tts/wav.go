@@ -0,0 +1,97 @@
+package tts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SampleRate is the sample rate of the generated audio, in Hz
+const SampleRate = 8000
+
+// WriteWAV writes a mono 16-bit PCM WAV file
+func WriteWAV(w io.Writer, samples []int16, sampleRate int) error {
+	dataLen := uint32(len(samples) * 2)
+	header := []any{
+		[4]byte{'R', 'I', 'F', 'F'},
+		36 + dataLen,
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),             // fmt chunk size
+		uint16(1),              // PCM
+		uint16(1),              // mono
+		uint32(sampleRate),     // sample rate
+		uint32(sampleRate * 2), // byte rate
+		uint16(2),              // block align
+		uint16(16),             // bits per sample
+		[4]byte{'d', 'a', 't', 'a'},
+		dataLen,
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return binary.Write(w, binary.LittleEndian, samples)
+}
+
+// ReadWAV returns the samples and sample rate of a mono 16-bit PCM WAV file
+func ReadWAV(data []byte) ([]int16, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+	sampleRate := 0
+	for chunks := data[12:]; len(chunks) >= 8; {
+		id, size := string(chunks[0:4]), int(binary.LittleEndian.Uint32(chunks[4:8]))
+		body := chunks[8:]
+		// Streamed files may leave the data size unset
+		if size > len(body) || (id == "data" && size == 0) {
+			size = len(body)
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("invalid WAV fmt chunk")
+			}
+			format, channels, bits := binary.LittleEndian.Uint16(body[0:2]), binary.LittleEndian.Uint16(body[2:4]), binary.LittleEndian.Uint16(body[14:16])
+			if format != 1 || channels != 1 || bits != 16 {
+				return nil, 0, fmt.Errorf("unsupported WAV format %d, %d channels, %d bits", format, channels, bits)
+			}
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+		case "data":
+			if sampleRate == 0 {
+				return nil, 0, errors.New("WAV data before the fmt chunk")
+			}
+			samples := make([]int16, size/2)
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(body[2*i:]))
+			}
+			return samples, sampleRate, nil
+		}
+		// Chunks are padded to an even size
+		chunks = body[min(size+size%2, len(body)):]
+	}
+	return nil, 0, errors.New("WAV file without data")
+}
+
+// Resample converts samples from one sample rate to another. Each output
+// sample averages the input samples it covers, which filters out most of the
+// frequencies the lower rate cannot carry.
+func Resample(samples []int16, from, to int) []int16 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+	out := make([]int16, int64(len(samples))*int64(to)/int64(from))
+	ratio := float64(from) / float64(to)
+	for i := range out {
+		lo := int(float64(i) * ratio)
+		hi := max(min(int(float64(i+1)*ratio), len(samples)), lo+1)
+		var sum float64
+		for _, s := range samples[lo:hi] {
+			sum += float64(s)
+		}
+		out[i] = int16(sum / float64(hi-lo))
+	}
+	return out
+}
@@ -2,47 +2,241 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"ari/internal/admin"
+	"ari/internal/ai"
+	"ari/internal/archive"
 	"ari/internal/ariutil"
+	"ari/internal/cdr"
+	"ari/internal/envelope"
+	"ari/internal/health"
 	"ari/internal/ivr"
+	"ari/internal/knowledge"
+	"ari/internal/logging"
+	"ari/internal/media"
+	"ari/internal/metrics"
+	"ari/internal/prompts"
+	"ari/internal/redact"
+	"ari/internal/retention"
+	"ari/internal/stt"
+	"ari/internal/tools"
+	"ari/internal/tracing"
+	"ari/internal/tts"
 
+	"github.com/CyCoreSystems/ari/v5"
 	"github.com/charmbracelet/log"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the instance and returns once it stopped, the deferred cleanups
+// done
+func run() error {
+	if err := logging.Setup(); err != nil {
+		return fmt.Errorf("logging setup failed: %w", err)
+	}
 
 	log.Info("Loging the environment variable", "variable", os.Getenv("ARI_USERNAME"))
 	if os.Getenv("ARI_USERNAME") == "" {
-		log.Fatal("Failed to retrieve the environnement variable")
+		return errors.New("Failed to retrieve the environnement variable")
 	}
 
+	// Speech and language backends, each a chain of providers with their own
+	// timeouts, retries, circuit breakers and concurrency limits
+	transcriber, err := stt.New()
+	if err != nil {
+		return fmt.Errorf("STT backend: %w", err)
+	}
+	synthesizer, err := tts.New()
+	if err != nil {
+		return fmt.Errorf("TTS backend: %w", err)
+	}
+	model, err := ai.New()
+	if err != nil {
+		return fmt.Errorf("LLM backend: %w", err)
+	}
+	backends := &ivr.Backends{STT: transcriber, TTS: synthesizer, LLM: model}
+	// Personal data masked in the logs and the records
+	if backends.Redactor, err = redact.FromEnv(); err != nil {
+		return fmt.Errorf("Redaction: %w", err)
+	}
+	// Business actions the LLM can take during the calls
+	if backends.Tools, err = tools.FromEnv(); err != nil {
+		return fmt.Errorf("LLM tools: %w", err)
+	}
+	// Documents the answers are taken from
+	if backends.Knowledge, err = knowledge.FromEnv(); err != nil {
+		return fmt.Errorf("Knowledge base: %w", err)
+	}
+	if backends.Knowledge != nil {
+		log.Info("Knowledge base loaded", "documents", len(backends.Knowledge.Index.Sources),
+			"passages", len(backends.Knowledge.Index.Chunks), "embedder", backends.Knowledge.Embedder.Name())
+	}
+	if backends.Tools != nil {
+		// Transfer, hangup, hold and DTMF, acting on the call of the turn
+		if err := ivr.RegisterCallTools(backends.Tools); err != nil {
+			return fmt.Errorf("LLM call tools: %w", err)
+		}
+	}
+	if names := toolNames(backends.Tools); len(names) > 0 {
+		log.Info("LLM tools enabled", "tools", names)
+	}
+
+	// Archived audio and records encrypted at rest
+	sealer, err := envelope.FromEnv()
+	if err != nil {
+		return fmt.Errorf("Encryption: %w", err)
+	}
+
+	// Call detail records
+	records, err := cdr.New(sealer)
+	if err != nil {
+		return fmt.Errorf("CDR store: %w", err)
+	}
+	defer records.Close()
+
+	// OpenTelemetry tracing, exported with OTLP when configured
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		return fmt.Errorf("tracing setup failed: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Create ARI client
 	cl, err := ariutil.NewARIClient()
 
 	if err != nil {
-		log.Fatal("connect failed", "err", err)
-		return
+		return fmt.Errorf("connect failed: %w", err)
 	}
 	log.Info("Client connected")
 	defer cl.Close()
 
+	// Transport of the generated audio to Asterisk
+	publisher, err := media.FromEnv(cl)
+	if err != nil {
+		return fmt.Errorf("Media: %w", err)
+	}
+	backends.Media = publisher
+	// Menu prompts rendered from the templates of PROMPTS_FILE
+	menuPrompts, err := prompts.Load(synthesizer, publisher)
+	if err != nil {
+		return fmt.Errorf("Prompts: %w", err)
+	}
+	backends.Prompts = menuPrompts
+	// What becomes of the audio of the ended calls
+	store, err := archive.New()
+	if err != nil {
+		return fmt.Errorf("Archive: %w", err)
+	}
+	if sealer != nil && store == nil {
+		return errors.New("ENCRYPTION is set without ARCHIVE, the audio of the calls would stay in clear in the recordings directory")
+	}
+	store = archive.Encrypt(store, sealer)
+	callRetention, err := retention.New(cl, store, ivr.TTSDir(), publisher)
+	if err != nil {
+		return fmt.Errorf("Retention: %w", err)
+	}
+	backends.Retention = callRetention
+
 	// Context for managing shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	go menuPrompts.Warm(ctx)
+	go callRetention.Run(ctx)
+
 	// Signal handling for graceful shutdown
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	// HTTP server for /metrics, the admin API and the health checks
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	admin.Register(mux)
+	if server, ok := publisher.(*media.HTTP); ok {
+		mux.Handle("GET /media/", server)
+	}
+	checks, err := healthChecks(cl, backends, sealer)
+	if err != nil {
+		return err
+	}
+	checks.Register(mux)
+	httpAddr := os.Getenv("HTTP_ADDR")
+	if httpAddr == "" {
+		httpAddr = ":8080"
+	}
+	go func() {
+		log.Info("HTTP server listening", "addr", httpAddr)
+		if err := http.ListenAndServe(httpAddr, mux); err != nil {
+			log.Error("HTTP server stopped", "err", err)
+		}
+	}()
+
 	go func() {
 		<-sigs
-		log.Warn("Shutdown request!")
+		log.Warn("Shutdown request! Draining the calls, send the signal again to stop at once")
 		cancel()
+		<-sigs
+		log.Fatal("Second shutdown request, stopping without draining")
 	}()
 
-	ivr.Start(ctx, cl)
+	// Returns once drained
+	if err := ivr.Start(ctx, cl, backends, records); err != nil {
+		return fmt.Errorf("IVR: %w", err)
+	}
+	return nil
+}
 
+// healthChecks returns the liveness and readiness checks of the instance
+func healthChecks(cl ari.Client, backends *ivr.Backends, sealer *envelope.Sealer) (*health.Checker, error) {
+	checks := &health.Checker{}
+	// The client reconnects by itself, restart only when it seems stuck
+	checks.Live("ari", health.ARIConnected(cl, time.Minute))
+	checks.Ready("ari", health.ARIConnected(cl, 0))
+	checks.Ready("drain", func(ctx context.Context) error {
+		if ivr.Draining() {
+			return errors.New("draining, new calls are rejected")
+		}
+		return nil
+	})
+	checks.Ready("tts_dir", health.Writable(ivr.TTSDir()))
+	// Backend probes go to paid APIs, at most once per interval
+	checks.Ready("stt", health.Cached(health.Ping(backends.STT), 30*time.Second))
+	checks.Ready("tts", health.Cached(health.Ping(backends.TTS), 30*time.Second))
+	checks.Ready("llm", health.Cached(health.Ping(backends.LLM), 30*time.Second))
+	if store := backends.Retention.Archive; store != nil {
+		checks.Ready("archive", health.Cached(health.Ping(store), 30*time.Second))
+	}
+	if sealer != nil {
+		checks.Ready("encryption", health.Cached(health.Ping(sealer), 30*time.Second))
+	}
+
+	if spec := os.Getenv("EXTERNAL_MEDIA_PORT"); spec != "" {
+		ports, err := health.ParsePorts(spec)
+		if err != nil {
+			return nil, fmt.Errorf("EXTERNAL_MEDIA_PORT: %w", err)
+		}
+		checks.Ready("external_media_ports", health.FreeUDPPort(ports))
+	}
+	return checks, nil
+}
+
+// toolNames lists the tools of the registry, for the startup log
+func toolNames(r *ai.Registry) []string {
+	var names []string
+	for _, t := range r.Tools() {
+		names = append(names, t.Name)
+	}
+	return names
 }